@@ -0,0 +1,4 @@
+// Package streamlinev1 holds the gRPC API of streamline, generated from event.proto.
+package streamlinev1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative streamline/v1/event.proto
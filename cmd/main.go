@@ -1,18 +1,36 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
 
+	streamlinev1 "streamline/api/streamline/v1"
 	"streamline/config"
 	"streamline/internal/handlers"
 	"streamline/internal/repositories"
 	"streamline/internal/usecases"
+	"streamline/pkg/auth"
+	"streamline/pkg/hub"
 	"streamline/pkg/kafka"
 	"streamline/pkg/redis"
+	"streamline/pkg/sse"
+	"streamline/pkg/ws"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+)
+
+// Servers the event routes can be served with.
+const (
+	serverNet   = "net"
+	serverFiber = "fiber"
+	serverBoth  = "both"
 )
 
 func init() {
@@ -34,37 +52,311 @@ func main() {
 	defer redisClient.Close()
 
 	kafkaClient, err := kafka.NewClient(kafka.Config{
-		Brokers: []string{config.Env.KafkaUrl},
+		Brokers:   strings.Split(config.Env.KafkaUrl, ","),
+		Username:  config.Env.KafkaUser,
+		Password:  config.Env.KafkaPassword,
+		Mechanism: config.Env.KafkaMechanism,
+		UseTLS:    config.Env.KafkaTLS,
+		TLS: kafka.TLSFiles{
+			CAFile:             config.Env.KafkaCAFile,
+			CertFile:           config.Env.KafkaCertFile,
+			KeyFile:            config.Env.KafkaKeyFile,
+			InsecureSkipVerify: config.Env.KafkaInsecure,
+		},
+		Producer: kafka.ProducerConfig{
+			Async:         config.Env.KafkaAsync,
+			Linger:        config.Env.KafkaLinger,
+			BatchBytes:    config.Env.KafkaBatchBytes,
+			BatchMessages: config.Env.KafkaBatchCount,
+			Compression:   config.Env.KafkaCompression,
+			Idempotent:    config.Env.KafkaIdempotent,
+			Acks:          config.Env.KafkaAcks,
+			Timeout:       config.Env.KafkaTimeout,
+		},
 	})
 	if err != nil {
 		log.Fatalf("Failed to setup Kafka client: %v", err)
 	}
 	defer kafkaClient.Close()
 
-	kafkaEventRepo := repositories.NewKafkaEventRepository(kafkaClient)
-	redisEventRepo := repositories.NewRedisEventRepository(redisClient)
-	eventUseCase := usecases.NewEventUseCase(redisEventRepo, kafkaEventRepo)
-	eventHandler := handlers.NewEventHandler(eventUseCase)
+	kafkaEventRepo := repositories.NewKafkaEventRepository(kafkaClient, newRouter())
+	redisEventRepo := repositories.NewRedisEventRepository(redisClient, repositories.RedisEventConfig{
+		StreamMaxLen: config.Env.RedisStreamMaxLen,
+		StateTTL:     config.Env.RedisStateTTL,
+		Outbox:       config.Env.EventSource != usecases.SourceKafka,
+	})
+
+	overflow, err := hub.ParsePolicy(config.Env.HubOverflow)
+	if err != nil {
+		log.Fatalf("Invalid hub overflow policy %q: %v", config.Env.HubOverflow, err)
+	}
+
+	eventUseCase := usecases.NewEventUseCase(redisEventRepo, kafkaEventRepo, usecases.EventConfig{
+		SubscriberBufferSize: config.Env.HubBufferSize,
+		Overflow:             overflow,
+		Source:               config.Env.EventSource,
+	})
+
+	switch config.Env.EventSource {
+	case usecases.SourceKafka:
+		startMaterializer(redisEventRepo, kafkaEventRepo)
+	case usecases.SourceRedis, "":
+		startRelay(redisClient, kafkaEventRepo)
+	default:
+		log.Fatalf("Invalid event source %q", config.Env.EventSource)
+	}
+
+	var authenticator auth.Authenticator
+	authorizer := auth.AllowAll()
+	if config.Env.AuthEnabled {
+		authenticator, err = newAuthenticator()
+		if err != nil {
+			log.Fatalf("Failed to setup authentication: %v", err)
+		}
+		authorizer = newAuthorizer()
+	}
+
+	eventHandler := handlers.NewEventHandler(eventUseCase, sse.Config{
+		KeepAlive:    config.Env.SSEKeepAlive,
+		MaxLifetime:  config.Env.SSEMaxLifetime,
+		Retry:        config.Env.SSERetry,
+		WriteTimeout: config.Env.SSEWriteTimeout,
+	}, ws.Config{
+		PingInterval:   config.Env.WSPingInterval,
+		PongTimeout:    config.Env.WSPongTimeout,
+		WriteTimeout:   config.Env.WSWriteTimeout,
+		SendBuffer:     config.Env.WSSendBuffer,
+		MaxMessageSize: config.Env.WSMaxMessageSize,
+		CheckOrigin:    newOriginChecker(config.Env.WSOrigins),
+	}, authorizer)
+
+	startGRPCServer(eventUseCase, authenticator, authorizer)
+
+	// Fiber streams only notice clients going away when a write fails, so they need keep-alives
+	if (config.Env.Server == serverFiber || config.Env.Server == serverBoth) && config.Env.SSEKeepAlive <= 0 {
+		log.Fatalf("Server %q requires an SSE keep-alive", config.Env.Server)
+	}
+
+	serverErr := make(chan error, 2)
+	switch config.Env.Server {
+	case serverNet, "":
+		startNetServer(eventHandler, authenticator, serverErr)
+	case serverFiber:
+		startFiberServer(eventHandler, authenticator, serverErr)
+	case serverBoth:
+		startNetServer(eventHandler, authenticator, serverErr)
+		startFiberServer(eventHandler, authenticator, serverErr)
+	default:
+		log.Fatalf("Invalid server %q", config.Env.Server)
+	}
+
+	log.Fatalf("Failed to start server: %v", <-serverErr)
+}
+
+// startNetServer serves the event routes with net/http in the background.
+func startNetServer(eventHandler handlers.EventHandler, authenticator auth.Authenticator, serverErr chan<- error) {
+	router := mux.NewRouter()
+	router.Use(handlers.NewTraceMiddleware())
+	if authenticator != nil {
+		router.Use(handlers.NewAuthMiddleware(authenticator))
+	}
+
+	handlers.MountNet(router, eventHandler)
+
+	go func() {
+		netServerAddress := ":" + config.Env.NetPort
+		log.Printf("Net/http server listening on %s\n", netServerAddress)
+		serverErr <- http.ListenAndServe(netServerAddress, router)
+	}()
+}
 
-	app := fiber.New()
+// startFiberServer serves the event routes with Fiber in the background.
+func startFiberServer(eventHandler handlers.EventHandler, authenticator auth.Authenticator, serverErr chan<- error) {
+	// Subscriptions outlive the handlers, so the values read from requests must not be
+	// backed by the buffers fasthttp recycles
+	app := fiber.New(fiber.Config{
+		Immutable:             true,
+		UnescapePath:          true,
+		DisableStartupMessage: true,
+	})
 
 	app.Get("/api/v1/fiber", func(c *fiber.Ctx) error {
 		return c.SendString("Response from Fiber!")
 	})
 
+	app.Use(handlers.NewFiberTraceMiddleware())
+	if authenticator != nil {
+		app.Use(handlers.NewFiberAuthMiddleware(authenticator))
+	}
+
+	handlers.MountFiber(app, eventHandler)
+
 	go func() {
-		if err := app.Listen(":" + config.Env.FiberPort); err != nil {
-			log.Fatalf("Fiber server failed to start: %v", err)
+		fiberServerAddress := ":" + config.Env.FiberPort
+		log.Printf("Fiber server listening on %s\n", fiberServerAddress)
+		serverErr <- app.Listen(fiberServerAddress)
+	}()
+}
+
+// startRelay delivers the events committed to Redis to Kafka in the background.
+func startRelay(redisClient redis.Client, kafkaEventRepo repositories.KafkaEventRepository) {
+	consumer := config.Env.OutboxConsumer
+	if consumer == "" {
+		var err error
+		if consumer, err = os.Hostname(); err != nil {
+			log.Fatalf("Failed to name the outbox consumer: %v", err)
+		}
+	}
+
+	outboxRepo := repositories.NewRedisOutboxRepository(redisClient, repositories.RedisOutboxConfig{
+		Group:        config.Env.OutboxGroup,
+		DeliveredTTL: config.Env.OutboxDedupTTL,
+	})
+	relayUseCase := usecases.NewRelayUseCase(outboxRepo, kafkaEventRepo, usecases.RelayConfig{
+		Consumer:      consumer,
+		BatchSize:     config.Env.OutboxBatch,
+		Block:         config.Env.OutboxBlock,
+		RetryInterval: config.Env.OutboxRetry,
+	})
+
+	go func() {
+		if err := relayUseCase.Run(context.Background()); err != nil {
+			log.Fatalf("Outbox relay failed to start: %v", err)
 		}
 	}()
+}
 
-	router := mux.NewRouter()
-	router.HandleFunc("/api/v1/event/{id:[^/]+}", eventHandler.StreamEvent).Methods(http.MethodGet)
-	router.HandleFunc("/api/v1/event/{id:[^/]+}", eventHandler.PatchEvent).Methods(http.MethodPatch)
+// startMaterializer applies the events of the Kafka topics to Redis in the background.
+func startMaterializer(redisEventRepo repositories.RedisEventRepository, kafkaEventRepo repositories.KafkaEventRepository) {
+	topics := config.Env.EventTopics
+	if len(topics) == 0 {
+		topics = kafkaEventRepo.Topics()
+	}
+	if len(topics) == 0 {
+		log.Fatalf("No topics to materialize the events from")
+	}
+
+	group, err := kafka.GroupName(config.Env.EventGroup, config.Env.EventGroupScope)
+	if err != nil {
+		log.Fatalf("Invalid consumer group scope %q: %v", config.Env.EventGroupScope, err)
+	}
+	// Replicas not sharing the group would consume the retries of each other
+	scope := config.Env.EventGroupScope
+	if config.Env.EventRetryTopic != "" && scope != "" && scope != kafka.GroupScopeShared {
+		log.Fatalf("Retry topic %q requires the %q consumer group scope", config.Env.EventRetryTopic, kafka.GroupScopeShared)
+	}
+
+	materializerUseCase := usecases.NewMaterializerUseCase(redisEventRepo, kafkaEventRepo, usecases.MaterializerConfig{
+		Topics: topics,
+		Group:  group,
+		Retry: kafka.RetryPolicy{
+			MaxAttempts: config.Env.EventRetries,
+			Backoff:     config.Env.EventBackoff,
+			MaxBackoff:  config.Env.EventMaxBackoff,
+		},
+		RetryTopic:      config.Env.EventRetryTopic,
+		DeadLetterTopic: config.Env.EventDeadLetter,
+	})
+
+	go func() {
+		if err := materializerUseCase.Run(context.Background()); err != nil {
+			log.Fatalf("Materializer failed to start: %v", err)
+		}
+	}()
+}
+
+// startGRPCServer serves the gRPC API on its own port in the background.
+func startGRPCServer(eventUseCase usecases.EventUseCase, authenticator auth.Authenticator, authorizer auth.Authorizer) {
+	listener, err := net.Listen("tcp", ":"+config.Env.GRPCPort)
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+
+	unary, stream := handlers.NewGRPCInterceptors(authenticator)
+	server := grpc.NewServer(grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+	streamlinev1.RegisterEventServiceServer(server, handlers.NewGRPCEventServer(eventUseCase, authorizer))
+
+	go func() {
+		log.Printf("gRPC server listening on %s\n", listener.Addr())
+		if err := server.Serve(listener); err != nil {
+			log.Fatalf("gRPC server failed to start: %v", err)
+		}
+	}()
+}
+
+// newRouter routes the channels to the configured Kafka topics.
+func newRouter() kafka.Router {
+	routes := make([]kafka.Route, 0, len(config.Env.KafkaRoutes))
+	for _, route := range config.Env.KafkaRoutes {
+		routes = append(routes, kafka.Route{
+			Prefix:  route.Prefix,
+			Pattern: route.Pattern,
+			Topic:   route.Topic,
+		})
+	}
+
+	router, err := kafka.NewRouter(routes, config.Env.KafkaTopic)
+	if err != nil {
+		log.Fatalf("Invalid Kafka routes: %v", err)
+	}
+
+	return router
+}
+
+// newAuthenticator chains the authenticators enabled in the configuration.
+func newAuthenticator() (auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
+
+	if len(config.Env.AuthAPIKeys) > 0 {
+		keys := make(map[string]string, len(config.Env.AuthAPIKeys))
+		for _, apiKey := range config.Env.AuthAPIKeys {
+			keys[apiKey.Key] = apiKey.Subject
+		}
+		authenticators = append(authenticators, auth.NewAPIKeyAuthenticator(keys))
+	}
+
+	if config.Env.AuthHMACSecret != "" {
+		authenticators = append(authenticators, auth.NewHMACAuthenticator(config.Env.AuthHMACSecret))
+	}
+
+	if config.Env.AuthJWKSFile != "" {
+		jwtAuthenticator, err := auth.NewJWTAuthenticator(auth.JWTConfig{
+			JWKSFile: config.Env.AuthJWKSFile,
+			Issuer:   config.Env.AuthJWTIssuer,
+			Audience: config.Env.AuthJWTAudience,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwtAuthenticator)
+	}
+
+	return auth.Chain(authenticators...), nil
+}
+
+// newAuthorizer builds the channel access policy from the configured rules.
+func newAuthorizer() auth.Authorizer {
+	rules := make([]auth.Rule, 0, len(config.Env.AuthRules))
+	for _, rule := range config.Env.AuthRules {
+		rules = append(rules, auth.Rule{
+			Subject: rule.Subject,
+			Pattern: rule.Pattern,
+			Read:    rule.Read,
+			Write:   rule.Write,
+		})
+	}
+
+	return auth.NewPolicy(rules)
+}
+
+// newOriginChecker accepts the WebSocket handshakes from the allowed origins, leaving
+// the default same host check in place when there are none.
+func newOriginChecker(origins []string) func(r *http.Request) bool {
+	if len(origins) == 0 {
+		return nil
+	}
 
-	netServerAddress := ":" + config.Env.NetPort
-	log.Printf("Net/http server listening on %s\n", netServerAddress)
-	if err := http.ListenAndServe(netServerAddress, router); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	return func(r *http.Request) bool {
+		return slices.Contains(origins, r.Header.Get("Origin"))
 	}
 }
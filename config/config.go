@@ -2,20 +2,95 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 var Env config
 
+type APIKey struct {
+	Key     string
+	Subject string
+}
+
+type AuthRule struct {
+	Subject string
+	Pattern string
+	Read    bool
+	Write   bool
+}
+
+type KafkaRoute struct {
+	Prefix  string
+	Pattern string
+	Topic   string
+}
+
 type config struct {
-	NetPort       string
-	FiberPort     string
-	RedisUrl      string
-	RedisUser     string
-	RedisPassword string
-	RedisDatabase int
-	KafkaUrl      string
+	Server            string
+	NetPort           string
+	FiberPort         string
+	GRPCPort          string
+	RedisUrl          string
+	RedisUser         string
+	RedisPassword     string
+	RedisDatabase     int
+	RedisStreamMaxLen int64
+	RedisStateTTL     time.Duration
+	KafkaUrl          string
+	KafkaTopic        string
+	KafkaUser         string
+	KafkaPassword     string
+	KafkaMechanism    string
+	KafkaTLS          bool
+	KafkaCAFile       string
+	KafkaCertFile     string
+	KafkaKeyFile      string
+	KafkaInsecure     bool
+	KafkaRoutes       []KafkaRoute
+	KafkaAsync        bool
+	KafkaLinger       time.Duration
+	KafkaBatchBytes   int
+	KafkaBatchCount   int
+	KafkaCompression  string
+	KafkaIdempotent   bool
+	KafkaAcks         string
+	KafkaTimeout      time.Duration
+	EventSource       string
+	EventTopics       []string
+	EventGroup        string
+	EventGroupScope   string
+	EventRetries      int
+	EventBackoff      time.Duration
+	EventMaxBackoff   time.Duration
+	EventRetryTopic   string
+	EventDeadLetter   string
+	OutboxGroup       string
+	OutboxConsumer    string
+	OutboxBatch       int64
+	OutboxBlock       time.Duration
+	OutboxRetry       time.Duration
+	OutboxDedupTTL    time.Duration
+	SSEKeepAlive      time.Duration
+	SSEMaxLifetime    time.Duration
+	SSERetry          time.Duration
+	SSEWriteTimeout   time.Duration
+	WSPingInterval    time.Duration
+	WSPongTimeout     time.Duration
+	WSWriteTimeout    time.Duration
+	WSSendBuffer      int
+	WSMaxMessageSize  int64
+	WSOrigins         []string
+	HubBufferSize     int
+	HubOverflow       string
+	AuthEnabled       bool
+	AuthAPIKeys       []APIKey
+	AuthHMACSecret    string
+	AuthJWKSFile      string
+	AuthJWTIssuer     string
+	AuthJWTAudience   string
+	AuthRules         []AuthRule
 }
 
 func LoadConfig() error {
@@ -30,13 +105,78 @@ func LoadConfig() error {
 	}
 
 	Env = config{
-		NetPort:       viper.GetString("net.port"),
-		FiberPort:     viper.GetString("fiber.port"),
-		RedisUrl:      viper.GetString("redis.host"),
-		RedisUser:     viper.GetString("redis.user"),
-		RedisPassword: viper.GetString("redis.pass"),
-		RedisDatabase: viper.GetInt("redis.db"),
-		KafkaUrl:      viper.GetString("kafka.url"),
+		Server:            viper.GetString("server"),
+		NetPort:           viper.GetString("net.port"),
+		FiberPort:         viper.GetString("fiber.port"),
+		GRPCPort:          viper.GetString("grpc.port"),
+		RedisUrl:          viper.GetString("redis.host"),
+		RedisUser:         viper.GetString("redis.user"),
+		RedisPassword:     viper.GetString("redis.pass"),
+		RedisDatabase:     viper.GetInt("redis.db"),
+		RedisStreamMaxLen: viper.GetInt64("redis.stream.maxlen"),
+		RedisStateTTL:     viper.GetDuration("redis.state.ttl"),
+		KafkaUrl:          viper.GetString("kafka.url"),
+		KafkaTopic:        viper.GetString("kafka.topic"),
+		KafkaUser:         viper.GetString("kafka.sasl.user"),
+		KafkaPassword:     viper.GetString("kafka.sasl.password"),
+		KafkaMechanism:    viper.GetString("kafka.sasl.mechanism"),
+		KafkaTLS:          viper.GetBool("kafka.tls.enabled"),
+		KafkaCAFile:       viper.GetString("kafka.tls.ca"),
+		KafkaCertFile:     viper.GetString("kafka.tls.cert"),
+		KafkaKeyFile:      viper.GetString("kafka.tls.key"),
+		KafkaInsecure:     viper.GetBool("kafka.tls.insecure"),
+		KafkaAsync:        viper.GetBool("kafka.producer.async"),
+		KafkaLinger:       viper.GetDuration("kafka.producer.linger"),
+		KafkaBatchBytes:   viper.GetInt("kafka.producer.batchbytes"),
+		KafkaBatchCount:   viper.GetInt("kafka.producer.batchmessages"),
+		KafkaCompression:  viper.GetString("kafka.producer.compression"),
+		KafkaIdempotent:   viper.GetBool("kafka.producer.idempotent"),
+		KafkaAcks:         viper.GetString("kafka.producer.acks"),
+		KafkaTimeout:      viper.GetDuration("kafka.producer.timeout"),
+		EventSource:       viper.GetString("events.source"),
+		EventTopics:       viper.GetStringSlice("events.topics"),
+		EventGroup:        viper.GetString("events.group"),
+		EventGroupScope:   viper.GetString("events.groupscope"),
+		EventRetries:      viper.GetInt("events.retry.attempts"),
+		EventBackoff:      viper.GetDuration("events.retry.backoff"),
+		EventMaxBackoff:   viper.GetDuration("events.retry.maxbackoff"),
+		EventRetryTopic:   viper.GetString("events.retry.topic"),
+		EventDeadLetter:   viper.GetString("events.deadletter.topic"),
+		OutboxGroup:       viper.GetString("outbox.group"),
+		OutboxConsumer:    viper.GetString("outbox.consumer"),
+		OutboxBatch:       viper.GetInt64("outbox.batch"),
+		OutboxBlock:       viper.GetDuration("outbox.block"),
+		OutboxRetry:       viper.GetDuration("outbox.retry"),
+		OutboxDedupTTL:    viper.GetDuration("outbox.dedupttl"),
+		SSEKeepAlive:      viper.GetDuration("sse.keepalive"),
+		SSEMaxLifetime:    viper.GetDuration("sse.lifetime"),
+		SSERetry:          viper.GetDuration("sse.retry"),
+		SSEWriteTimeout:   viper.GetDuration("sse.writetimeout"),
+		WSPingInterval:    viper.GetDuration("ws.ping"),
+		WSPongTimeout:     viper.GetDuration("ws.pongtimeout"),
+		WSWriteTimeout:    viper.GetDuration("ws.writetimeout"),
+		WSSendBuffer:      viper.GetInt("ws.buffer"),
+		WSMaxMessageSize:  viper.GetInt64("ws.maxmessage"),
+		WSOrigins:         viper.GetStringSlice("ws.origins"),
+		HubBufferSize:     viper.GetInt("hub.buffer"),
+		HubOverflow:       viper.GetString("hub.overflow"),
+		AuthEnabled:       viper.GetBool("auth.enabled"),
+		AuthHMACSecret:    viper.GetString("auth.hmac.secret"),
+		AuthJWKSFile:      viper.GetString("auth.jwt.jwks"),
+		AuthJWTIssuer:     viper.GetString("auth.jwt.issuer"),
+		AuthJWTAudience:   viper.GetString("auth.jwt.audience"),
+	}
+
+	if err := viper.UnmarshalKey("kafka.routes", &Env.KafkaRoutes); err != nil {
+		return err
+	}
+
+	if err := viper.UnmarshalKey("auth.apikeys", &Env.AuthAPIKeys); err != nil {
+		return err
+	}
+
+	if err := viper.UnmarshalKey("auth.rules", &Env.AuthRules); err != nil {
+		return err
 	}
 
 	return nil
@@ -1,6 +1,112 @@
 package entities
 
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Event types, sent as the SSE event name so clients can listen for each one.
+const (
+	EventTypeSnapshot = "snapshot"
+	EventTypePatch    = "patch"
+	EventTypeDeleted  = "deleted"
+
+	// EventTypeSlowConsumer is the last event of a subscriber that fell too far behind.
+	EventTypeSlowConsumer = "slow-consumer"
+)
+
+// Patch formats accepted to update the state of a channel, named after their media types.
+const (
+	PatchTypeReplace   = "application/json"
+	PatchTypeMerge     = "application/merge-patch+json" // RFC 7386
+	PatchTypeJSONPatch = "application/json-patch+json"  // RFC 6902
+)
+
+// Headers of the Kafka records holding events, so consumers can route and correlate
+// them without decoding the events.
+const (
+	HeaderEventID     = "event-id"
+	HeaderVersion     = "version"
+	HeaderContentType = "content-type"
+	HeaderPublisher   = "publisher"
+	HeaderTraceParent = "traceparent"
+	// HeaderIfMatch carries the precondition of the update, as a comma-separated list
+	// of versions or * for any existing state, enforced when it is materialized.
+	HeaderIfMatch = "if-match"
+
+	// ContentTypeEvent is the content type of the records, a JSON-encoded Event.
+	ContentTypeEvent = "application/json"
+)
+
+// Delivery modes a subscriber chooses between for patch events.
+const (
+	// DeliveryDocument delivers the resulting document of every patch.
+	DeliveryDocument = "document"
+	// DeliveryPatch delivers the patch itself, to be applied to the previous document.
+	DeliveryPatch = "patch"
+)
+
 type Event struct {
-	Id      string  `json:"id"`
-	Message *string `json:"message"`
+	Id        string          `json:"id"`
+	Type      string          `json:"type,omitempty"`
+	Version   int64           `json:"version"`
+	Message   json.RawMessage `json:"message"`
+	Patch     json.RawMessage `json:"patch,omitempty"`
+	PatchType string          `json:"patchType,omitempty"`
+	Replay    bool            `json:"replay,omitempty"`
+
+	Publisher   string `json:"publisher,omitempty"`
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+// EventID returns the version of the event, which is used as the SSE event ID.
+// Channels without state are at version 0, which has no ID.
+func (e Event) EventID() string {
+	if e.Version == 0 {
+		return ""
+	}
+	return strconv.FormatInt(e.Version, 10)
+}
+
+// EventName returns the type of the event, which is used as the SSE event name.
+func (e Event) EventName() string {
+	return e.Type
+}
+
+// Headers returns the headers of the Kafka record holding the event. The version is
+// omitted until the event is versioned.
+func (e Event) Headers() map[string]string {
+	headers := map[string]string{
+		HeaderEventID:     e.Id,
+		HeaderContentType: ContentTypeEvent,
+	}
+
+	if e.Version != 0 {
+		headers[HeaderVersion] = strconv.FormatInt(e.Version, 10)
+	}
+	if e.Publisher != "" {
+		headers[HeaderPublisher] = e.Publisher
+	}
+	if e.TraceParent != "" {
+		headers[HeaderTraceParent] = e.TraceParent
+	}
+
+	return headers
+}
+
+// ForDelivery strips what a subscriber did not ask for from a patch event:
+// the patch in document mode, the resulting document in patch mode.
+func (e Event) ForDelivery(mode string) Event {
+	if e.Type != EventTypePatch {
+		return e
+	}
+
+	if mode == DeliveryPatch {
+		e.Message = nil
+		return e
+	}
+
+	e.Patch = nil
+	e.PatchType = ""
+	return e
 }
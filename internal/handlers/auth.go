@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	"streamline/pkg/auth"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	MsgUnauthorized = "Unauthorized"
+	MsgForbidden    = "Forbidden"
+
+	errAuthenticate = "Error authenticating request to %s: %v"
+)
+
+// queryTokenPaths are the routes browsers open with EventSource or WebSocket, which may
+// carry their token in the query string since those cannot set headers.
+var queryTokenPaths = regexp.MustCompile(`^/api/v1/(event/[^/]+|events|ws)$`)
+
+// NewAuthMiddleware rejects requests the authenticator cannot identify and
+// stores the identity of the others in the request context.
+func NewAuthMiddleware(authenticator auth.Authenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, err := authenticate(authenticator, r)
+			if err != nil {
+				if !errors.Is(err, auth.ErrNoCredentials) {
+					log.Printf(errAuthenticate, r.URL.Path, err)
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="streamline"`)
+				http.Error(w, MsgUnauthorized, http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+		})
+	}
+}
+
+// authenticate identifies the caller of the request, reading a token from the query
+// string on the stream routes only.
+func authenticate(authenticator auth.Authenticator, r *http.Request) (*auth.Identity, error) {
+	if queryTokenPaths.MatchString(r.URL.Path) {
+		r = auth.WithQueryToken(r)
+	}
+	return authenticator.Authenticate(r)
+}
+
+// authorize reports whether the caller of the request may act on the channel,
+// answering 403 Forbidden when it may not.
+func authorize(res Response, req Request, authorizer auth.Authorizer, chID string, permission auth.Permission) bool {
+	if authorizer.Allowed(auth.IdentityFrom(req.Context()), chID, permission) {
+		return true
+	}
+
+	res.Error(MsgForbidden, http.StatusForbidden)
+	return false
+}
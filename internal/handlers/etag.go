@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"streamline/internal/usecases"
+)
+
+const (
+	ETagHeader        = "ETag"
+	IfMatchHeader     = "If-Match"
+	IfNoneMatchHeader = "If-None-Match"
+
+	MsgPreconditionFailed = "Precondition failed"
+)
+
+// etag returns the strong entity tag of a state version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETags parses the list of entity tags of a conditional header. It reports whether
+// the list is "*". Weak tags are ignored since they never match a strong comparison.
+func parseETags(header string) ([]int64, bool) {
+	var versions []int64
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil, true
+		}
+
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+
+		if version, err := usecases.ParseVersion(tag[1 : len(tag)-1]); err == nil {
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, false
+}
+
+// parsePrecondition turns the If-Match header of the request into the precondition of
+// an update. It reports false, answering 412 Precondition Failed, when the header holds
+// no tag that could ever match.
+func parsePrecondition(res Response, req Request) (usecases.Precondition, bool) {
+	header := req.Header(IfMatchHeader)
+	if header == "" {
+		return usecases.Precondition{}, true
+	}
+
+	versions, wildcard := parseETags(header)
+	if wildcard {
+		return usecases.Precondition{Exists: true}, true
+	}
+
+	if len(versions) == 0 {
+		res.Error(MsgPreconditionFailed, http.StatusPreconditionFailed)
+		return usecases.Precondition{}, false
+	}
+
+	return usecases.Precondition{Versions: versions}, true
+}
+
+// notModified reports whether the If-None-Match header of the request matches the version.
+func notModified(req Request, version int64) bool {
+	header := req.Header(IfNoneMatchHeader)
+	if header == "" {
+		return false
+	}
+
+	// If-None-Match uses the weak comparison, so W/ prefixes are ignored
+	versions, wildcard := parseETags(strings.ReplaceAll(header, "W/", ""))
+	if wildcard {
+		return true
+	}
+
+	for _, candidate := range versions {
+		if candidate == version {
+			return true
+		}
+	}
+
+	return false
+}
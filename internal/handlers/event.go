@@ -1,82 +1,374 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
 
 	"streamline/internal/entities"
 	"streamline/internal/usecases"
+	"streamline/pkg/auth"
+	"streamline/pkg/hub"
 	"streamline/pkg/sse"
+	"streamline/pkg/ws"
 
 	"github.com/bondzai/gogear/toolbox"
-	"github.com/gorilla/mux"
 )
 
 const (
-	MsgCanNotParseRequest = "Cannot parse request"
-	MsgMissingEventID     = "Missing event ID"
-	MsgUnexpectedErr      = "Unexpected error"
+	MsgCanNotParseRequest  = "Cannot parse request"
+	MsgMissingEventID      = "Missing event ID"
+	MsgUnexpectedErr       = "Unexpected error"
+	MsgInvalidStreamOption = "Invalid stream option: "
+	MsgMissingChannels     = "Missing channel or pattern"
+	MsgStateNotFound       = "Event state not found"
+	MsgUnsupportedPatch    = "Unsupported patch type"
+	MsgTooManyChannels     = "Too many channels and patterns"
+	MsgPatchTooLarge       = "Patch too large"
+
+	maxChannelsPerStream = 64
+	maxPatchSize         = 1 << 20
+
+	errStreamClient = "Error streaming to client %s: %v"
+
+	// eventStreamError is the name of the last frame of a stream ended by the server.
+	eventStreamError = "error"
+)
+
+var (
+	errInvalidMode   = errors.New("invalid delivery mode")
+	errPatchTooLarge = errors.New("patch too large")
 )
 
+// streamError is the data of the last frame of a stream ended by the server.
+type streamError struct {
+	Reason string `json:"reason"`
+}
+
+// EventHandler serves the event routes on any server, see MountNet and MountFiber.
+// The WebSocket endpoint is only served over net/http.
 type EventHandler interface {
-	StreamEvent(w http.ResponseWriter, r *http.Request)
-	PatchEvent(w http.ResponseWriter, r *http.Request)
+	StreamEvent(res Response, req Request)
+	StreamEvents(res Response, req Request)
+	PollEvent(res Response, req Request)
+	PatchEvent(res Response, req Request)
+	DeleteEvent(res Response, req Request)
+	GetState(res Response, req Request)
+	GetStats(res Response, req Request)
+	WebSocket(w http.ResponseWriter, r *http.Request)
 }
 
 type eventHandler struct {
 	eventUseCase usecases.EventUseCase
+	sseConfig    sse.Config
+	wsConfig     ws.Config
+	authorizer   auth.Authorizer
 }
 
-func NewEventHandler(eventUseCase usecases.EventUseCase) EventHandler {
-	return &eventHandler{eventUseCase: eventUseCase}
+func NewEventHandler(eventUseCase usecases.EventUseCase, sseConfig sse.Config, wsConfig ws.Config, authorizer auth.Authorizer) EventHandler {
+	return &eventHandler{
+		eventUseCase: eventUseCase,
+		sseConfig:    sseConfig,
+		wsConfig:     wsConfig,
+		authorizer:   authorizer,
+	}
 }
 
-func (h *eventHandler) StreamEvent(w http.ResponseWriter, r *http.Request) {
-	chID := mux.Vars(r)["id"]
+func (h *eventHandler) StreamEvent(res Response, req Request) {
+	chID := req.Param("id")
 	if chID == "" {
-		http.Error(w, MsgMissingEventID, http.StatusBadRequest)
+		res.Error(MsgMissingEventID, http.StatusBadRequest)
 		return
 	}
 
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
-
-	eventCh := make(chan entities.Event)
+	if !authorize(res, req, h.authorizer, chID, auth.PermissionRead) {
+		return
+	}
 
-	// The use case is responsible for closing the 'eventCh' channel
-	if err := h.eventUseCase.SubscribeAndStreamEvent(ctx, chID, eventCh); err != nil {
-		http.Error(w, MsgUnexpectedErr, http.StatusInternalServerError)
+	options, err := parseStreamOptions(req)
+	if err != nil {
+		res.Error(MsgInvalidStreamOption+err.Error(), http.StatusBadRequest)
 		return
 	}
+	options.LastEventID = req.Header(sse.LastEventIDHeader)
 
-	if err := sse.Stream(ctx, w, eventCh); err != nil {
-		http.Error(w, MsgUnexpectedErr, http.StatusInternalServerError)
+	if from := req.Query("from"); from != "" {
+		if options.From, err = usecases.ParseReplayFrom(from); err != nil {
+			res.Error(MsgInvalidStreamOption+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub, err := h.eventUseCase.SubscribeAndStreamEvent(req.Context(), chID, options)
+	if err != nil {
+		res.Error(MsgUnexpectedErr, http.StatusInternalServerError)
 		return
 	}
 
+	res.Stream(sub, h.sseConfig)
+
 	toolbox.TrackRoutines()
 }
 
-func (h *eventHandler) PatchEvent(w http.ResponseWriter, r *http.Request) {
+// StreamEvents streams several channels, and every channel matching the given
+// patterns, over a single connection: /api/v1/events?channel=a&channel=b&pattern=orders.*
+func (h *eventHandler) StreamEvents(res Response, req Request) {
+	chIDs := req.QueryAll("channel")
+	patterns := req.QueryAll("pattern")
+
+	if len(chIDs) == 0 && len(patterns) == 0 {
+		res.Error(MsgMissingChannels, http.StatusBadRequest)
+		return
+	}
+	if len(chIDs)+len(patterns) > maxChannelsPerStream {
+		res.Error(MsgTooManyChannels, http.StatusBadRequest)
+		return
+	}
+
+	for _, chID := range chIDs {
+		if !authorize(res, req, h.authorizer, chID, auth.PermissionRead) {
+			return
+		}
+	}
+
+	options, err := parseStreamOptions(req)
+	if err != nil {
+		res.Error(MsgInvalidStreamOption+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Pattern matches are only delivered for the channels the caller may read
+	identity := auth.IdentityFrom(req.Context())
+	options.Authorize = func(chID string) bool {
+		return h.authorizer.Allowed(identity, chID, auth.PermissionRead)
+	}
+
+	sub, err := h.eventUseCase.SubscribeAndStreamEvents(req.Context(), chIDs, patterns, options)
+	if err != nil {
+		res.Error(MsgUnexpectedErr, http.StatusInternalServerError)
+		return
+	}
+
+	res.Stream(sub, h.sseConfig)
+}
+
+// finalFrame returns the error frame telling the client why the subscription ended,
+// when it ended on its own, e.g. so it reconnects with Last-Event-ID after being cut
+// off for falling behind.
+func finalFrame(sub usecases.Subscription) (sse.Event, bool) {
+	var streamErr *usecases.StreamError
+	if !errors.As(sub.Err(), &streamErr) || streamErr.Reason == usecases.ReasonCanceled {
+		return sse.Event{}, false
+	}
+
+	return sse.Event{
+		Event: eventStreamError,
+		Data:  streamError{Reason: streamErr.Reason},
+	}, true
+}
+
+// parseStreamOptions reads the options a subscriber can choose in the query string.
+func parseStreamOptions(req Request) (usecases.StreamOptions, error) {
+	options := usecases.StreamOptions{
+		Mode: req.Query("mode"),
+	}
+
+	switch options.Mode {
+	case "", entities.DeliveryDocument, entities.DeliveryPatch:
+	default:
+		return options, errInvalidMode
+	}
+
+	if overflow := req.Query("overflow"); overflow != "" {
+		policy, err := hub.ParsePolicy(overflow)
+		if err != nil {
+			return options, err
+		}
+		options.Overflow = policy
+	}
+
+	return options, nil
+}
+
+// PatchEvent updates the channel's state according to the Content-Type of the request:
+// application/merge-patch+json (RFC 7386), application/json-patch+json (RFC 6902), or
+// application/json with an event whose message replaces the whole document.
+// The update is only applied when the state still matches the If-Match header, if any,
+// and the ETag of the response is the new version.
+func (h *eventHandler) PatchEvent(res Response, req Request) {
+	patchType, patch, err := readPatch(req)
+	if errors.Is(err, errPatchTooLarge) {
+		res.Error(MsgPatchTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		res.Error(MsgCanNotParseRequest+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chID := req.Param("id")
+	if chID == "" {
+		res.Error(MsgMissingEventID, http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(res, req, h.authorizer, chID, auth.PermissionWrite) {
+		return
+	}
+
+	precondition, ok := parsePrecondition(res, req)
+	if !ok {
+		return
+	}
+
+	event, err := h.eventUseCase.PublishEvent(req.Context(), chID, patchType, patch, precondition)
+	switch {
+	case errors.Is(err, usecases.ErrPreconditionFailed):
+		res.Error(MsgPreconditionFailed, http.StatusPreconditionFailed)
+		return
+	case errors.Is(err, usecases.ErrPatchType):
+		res.Error(MsgUnsupportedPatch, http.StatusUnsupportedMediaType)
+		return
+	case errors.Is(err, usecases.ErrInvalidPatch):
+		res.Error(MsgCanNotParseRequest+err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, usecases.ErrPatchFailed):
+		res.Error(err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		res.Error(MsgUnexpectedErr, http.StatusInternalServerError)
+		return
+	}
+
+	writeCommitted(res, event)
+}
+
+// readPatch reads the body of a PATCH request along with its patch type. A body over
+// maxPatchSize is rejected with errPatchTooLarge rather than truncated.
+func readPatch(req Request) (string, json.RawMessage, error) {
+	patchType := entities.PatchTypeReplace
+	if contentType := req.Header("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return "", nil, err
+		}
+		patchType = mediaType
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body(), maxPatchSize+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(body) > maxPatchSize {
+		return "", nil, errPatchTooLarge
+	}
+
+	if patchType != entities.PatchTypeReplace {
+		return patchType, body, nil
+	}
+
+	// Plain JSON requests carry an event, its message being the new document
 	var request entities.Event
-	err := json.NewDecoder(r.Body).Decode(&request)
+	if err := json.Unmarshal(body, &request); err != nil {
+		return "", nil, err
+	}
+	if request.Message == nil {
+		request.Message = json.RawMessage("null")
+	}
+
+	return patchType, request.Message, nil
+}
+
+func (h *eventHandler) DeleteEvent(res Response, req Request) {
+	chID := req.Param("id")
+	if chID == "" {
+		res.Error(MsgMissingEventID, http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(res, req, h.authorizer, chID, auth.PermissionWrite) {
+		return
+	}
+
+	precondition, ok := parsePrecondition(res, req)
+	if !ok {
+		return
+	}
+
+	event, err := h.eventUseCase.DeleteEvent(req.Context(), chID, precondition)
+	if errors.Is(err, usecases.ErrPreconditionFailed) {
+		res.Error(MsgPreconditionFailed, http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
-		http.Error(w, MsgCanNotParseRequest+err.Error(), http.StatusBadRequest)
+		res.Error(MsgUnexpectedErr, http.StatusInternalServerError)
 		return
 	}
 
-	chID := mux.Vars(r)["id"]
+	writeCommitted(res, event)
+}
+
+func (h *eventHandler) GetState(res Response, req Request) {
+	chID := req.Param("id")
 	if chID == "" {
-		http.Error(w, MsgMissingEventID, http.StatusBadRequest)
+		res.Error(MsgMissingEventID, http.StatusBadRequest)
 		return
 	}
 
-	err = h.eventUseCase.PublishEvent(chID, request)
+	if !authorize(res, req, h.authorizer, chID, auth.PermissionRead) {
+		return
+	}
+
+	state, err := h.eventUseCase.GetState(chID)
+	if errors.Is(err, usecases.ErrStateNotFound) {
+		res.Error(MsgStateNotFound, http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, MsgUnexpectedErr, http.StatusInternalServerError)
+		res.Error(MsgUnexpectedErr, http.StatusInternalServerError)
+		return
+	}
+
+	res.SetHeader(ETagHeader, etag(state.Version))
+	if notModified(req, state.Version) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if err := res.JSON(state); err != nil {
+		res.Error(MsgUnexpectedErr, http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetStats returns the fan-out counters of the channels the caller may read.
+func (h *eventHandler) GetStats(res Response, req Request) {
+	identity := auth.IdentityFrom(req.Context())
+
+	stats := make(map[string]hub.Stats)
+	for chID, channelStats := range h.eventUseCase.Stats() {
+		if h.authorizer.Allowed(identity, chID, auth.PermissionRead) {
+			stats[chID] = channelStats
+		}
+	}
+
+	if err := res.JSON(stats); err != nil {
+		res.Error(MsgUnexpectedErr, http.StatusInternalServerError)
+		return
+	}
+}
+
+// writeCommitted answers a successful update with the new version of the state, or
+// with 202 Accepted when the event is only versioned once materialized from Kafka, where
+// an If-Match precondition no longer met by then sends it to the dead-letter topic.
+func writeCommitted(res Response, event *entities.Event) {
+	if event.Version == 0 {
+		res.WriteHeader(http.StatusAccepted)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	res.SetHeader(ETagHeader, etag(event.Version))
+	res.WriteHeader(http.StatusNoContent)
 }
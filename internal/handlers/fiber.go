@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"streamline/internal/usecases"
+	"streamline/pkg/auth"
+	"streamline/pkg/sse"
+	"streamline/pkg/tracing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+)
+
+type (
+	fiberRequest struct {
+		c *fiber.Ctx
+	}
+
+	fiberResponse struct {
+		c *fiber.Ctx
+	}
+)
+
+// MountFiber mounts the event routes on a Fiber router. The app must be created with
+// Immutable set, since subscriptions outlive the handlers that open them.
+func MountFiber(router fiber.Router, handler EventHandler) {
+	for _, route := range routes(handler) {
+		path := strings.NewReplacer("{id}", ":id").Replace(route.path)
+		router.Add(route.method, path, FiberHandler(route.handler))
+	}
+}
+
+// FiberHandler adapts a handler to Fiber.
+func FiberHandler(handler HandlerFunc) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		handler(&fiberResponse{c: c}, &fiberRequest{c: c})
+		return nil
+	}
+}
+
+// NewFiberTraceMiddleware is NewTraceMiddleware for Fiber, storing the traceparent in
+// the user context of the request.
+func NewFiberTraceMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		traceParent := tracing.Child(c.Get(tracing.TraceParentHeader))
+		c.SetUserContext(tracing.WithTraceParent(c.UserContext(), traceParent))
+		return c.Next()
+	}
+}
+
+// NewFiberAuthMiddleware is NewAuthMiddleware for Fiber, storing the identity in the user
+// context of the request.
+func NewFiberAuthMiddleware(authenticator auth.Authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		r, err := adaptor.ConvertRequest(c, false)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, MsgCanNotParseRequest)
+		}
+
+		identity, err := authenticate(authenticator, r)
+		if err != nil {
+			if !errors.Is(err, auth.ErrNoCredentials) {
+				log.Printf(errAuthenticate, c.Path(), err)
+			}
+			c.Set("WWW-Authenticate", `Bearer realm="streamline"`)
+			(&fiberResponse{c: c}).Error(MsgUnauthorized, http.StatusUnauthorized)
+			return nil
+		}
+
+		c.SetUserContext(auth.WithIdentity(c.UserContext(), identity))
+		return c.Next()
+	}
+}
+
+func (req *fiberRequest) Context() context.Context {
+	return req.c.UserContext()
+}
+
+func (req *fiberRequest) Param(name string) string {
+	return req.c.Params(name)
+}
+
+func (req *fiberRequest) Query(name string) string {
+	return req.c.Query(name)
+}
+
+func (req *fiberRequest) QueryAll(name string) []string {
+	var values []string
+	for _, value := range req.c.Context().QueryArgs().PeekMulti(name) {
+		values = append(values, string(value))
+	}
+	return values
+}
+
+func (req *fiberRequest) Header(name string) string {
+	return req.c.Get(name)
+}
+
+func (req *fiberRequest) Body() io.Reader {
+	return bytes.NewReader(req.c.Body())
+}
+
+func (req *fiberRequest) Path() string {
+	return req.c.Path()
+}
+
+func (res *fiberResponse) SetHeader(name, value string) {
+	res.c.Set(name, value)
+}
+
+func (res *fiberResponse) WriteHeader(code int) {
+	res.c.Status(code)
+}
+
+func (res *fiberResponse) Error(message string, code int) {
+	res.c.Set("Content-Type", "text/plain; charset=utf-8")
+	res.c.Set("X-Content-Type-Options", "nosniff")
+	_ = res.c.Status(code).SendString(message + "\n")
+}
+
+func (res *fiberResponse) JSON(v interface{}) error {
+	return res.c.JSON(v)
+}
+
+// Stream hands the subscription to the body stream writer of fasthttp, which runs once
+// the handler returned. fasthttp does not report clients going away, they are detected
+// when a write fails, at the latest on the next keep-alive.
+func (res *fiberResponse) Stream(sub usecases.Subscription, config sse.Config) {
+	res.c.Set(sse.ContentTypeHeader, sse.ContentTypeValue)
+	res.c.Set(sse.CacheControlHeader, sse.CacheControlValue)
+	res.c.Set(sse.ConnectionHeader, sse.ConnectionValue)
+
+	// The request context is recycled once the handler returns
+	ctx := res.c.UserContext()
+	path := res.c.Path()
+
+	res.c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+
+		if err := sse.StreamBuffered(ctx, w, sub.Events(), config); err != nil {
+			log.Printf(errStreamClient, path, err)
+			return
+		}
+
+		if frame, ok := finalFrame(sub); ok {
+			if err := sse.SendBuffered(w, frame); err != nil {
+				log.Printf(errStreamClient, path, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	streamlinev1 "streamline/api/streamline/v1"
+	"streamline/internal/entities"
+	"streamline/internal/usecases"
+	"streamline/pkg/auth"
+	"streamline/pkg/hub"
+	"streamline/pkg/tracing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	errGRPCSubscribe = "Error subscribing gRPC client to channel %s: %v"
+	errGRPCStream    = "Error streaming to gRPC client for channel %s: %v"
+)
+
+// grpcEventServer serves the gRPC API with the same use case, authorizer and error
+// mapping as the HTTP API.
+type grpcEventServer struct {
+	streamlinev1.UnimplementedEventServiceServer
+
+	eventUseCase usecases.EventUseCase
+	authorizer   auth.Authorizer
+}
+
+func NewGRPCEventServer(eventUseCase usecases.EventUseCase, authorizer auth.Authorizer) streamlinev1.EventServiceServer {
+	return &grpcEventServer{
+		eventUseCase: eventUseCase,
+		authorizer:   authorizer,
+	}
+}
+
+func (s *grpcEventServer) Publish(ctx context.Context, request *streamlinev1.PublishRequest) (*streamlinev1.PublishResponse, error) {
+	event, err := s.publish(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamlinev1.PublishResponse{Channel: request.Channel, Version: event.Version}, nil
+}
+
+// PublishStream applies every update of the stream, the failure of one not preventing
+// the next ones from being applied.
+func (s *grpcEventServer) PublishStream(stream streamlinev1.EventService_PublishStreamServer) error {
+	var results []*streamlinev1.PublishResult
+
+	for {
+		request, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&streamlinev1.PublishStreamResponse{Results: results})
+		}
+		if err != nil {
+			return err
+		}
+
+		result := &streamlinev1.PublishResult{Channel: request.Channel}
+		if event, err := s.publish(stream.Context(), request); err != nil {
+			result.Code = int32(status.Code(err))
+			result.Message = status.Convert(err).Message()
+		} else {
+			result.Version = event.Version
+		}
+		results = append(results, result)
+	}
+}
+
+// publish applies an update, returning a status error when it fails.
+func (s *grpcEventServer) publish(ctx context.Context, request *streamlinev1.PublishRequest) (*entities.Event, error) {
+	if request.Channel == "" {
+		return nil, status.Error(codes.InvalidArgument, MsgMissingEventID)
+	}
+	if !s.authorizer.Allowed(auth.IdentityFrom(ctx), request.Channel, auth.PermissionWrite) {
+		return nil, status.Error(codes.PermissionDenied, MsgForbidden)
+	}
+
+	patchType := request.PatchType
+	if patchType == "" {
+		patchType = entities.PatchTypeReplace
+	}
+	patch := request.Patch
+	if patch == nil {
+		patch = []byte("null")
+	}
+	precondition := usecases.Precondition{
+		Versions: request.IfMatch,
+		Exists:   request.MustExist,
+	}
+
+	event, err := s.eventUseCase.PublishEvent(ctx, request.Channel, patchType, patch, precondition)
+	switch {
+	case errors.Is(err, usecases.ErrPreconditionFailed):
+		return nil, status.Error(codes.FailedPrecondition, MsgPreconditionFailed)
+	case errors.Is(err, usecases.ErrPatchType):
+		return nil, status.Error(codes.InvalidArgument, MsgUnsupportedPatch)
+	case errors.Is(err, usecases.ErrInvalidPatch):
+		return nil, status.Error(codes.InvalidArgument, MsgCanNotParseRequest+err.Error())
+	case errors.Is(err, usecases.ErrPatchFailed):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, MsgUnexpectedErr)
+	}
+
+	return event, nil
+}
+
+// Subscribe streams the events of the channel until the client cancels the call or the
+// subscription ends, in which case the final status tells why.
+func (s *grpcEventServer) Subscribe(request *streamlinev1.SubscribeRequest, stream streamlinev1.EventService_SubscribeServer) error {
+	ctx := stream.Context()
+
+	if request.Channel == "" {
+		return status.Error(codes.InvalidArgument, MsgMissingEventID)
+	}
+	if !s.authorizer.Allowed(auth.IdentityFrom(ctx), request.Channel, auth.PermissionRead) {
+		return status.Error(codes.PermissionDenied, MsgForbidden)
+	}
+
+	options := usecases.StreamOptions{LastEventID: request.ResumeToken}
+	switch request.Mode {
+	case streamlinev1.DeliveryMode_DELIVERY_MODE_UNSPECIFIED, streamlinev1.DeliveryMode_DELIVERY_MODE_DOCUMENT:
+		options.Mode = entities.DeliveryDocument
+	case streamlinev1.DeliveryMode_DELIVERY_MODE_PATCH:
+		options.Mode = entities.DeliveryPatch
+	default:
+		return status.Error(codes.InvalidArgument, MsgInvalidStreamOption+errInvalidMode.Error())
+	}
+	if request.Overflow != "" {
+		policy, err := hub.ParsePolicy(request.Overflow)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, MsgInvalidStreamOption+err.Error())
+		}
+		options.Overflow = policy
+	}
+
+	sub, err := s.eventUseCase.SubscribeAndStreamEvent(ctx, request.Channel, options)
+	if err != nil {
+		log.Printf(errGRPCSubscribe, request.Channel, err)
+		return status.Error(codes.Internal, MsgUnexpectedErr)
+	}
+	defer sub.Close()
+
+	for event := range sub.Events() {
+		if err := stream.Send(toProtoEvent(event)); err != nil {
+			log.Printf(errGRPCStream, request.Channel, err)
+			return err
+		}
+	}
+
+	var streamErr *usecases.StreamError
+	if !errors.As(sub.Err(), &streamErr) {
+		return nil
+	}
+
+	switch streamErr.Reason {
+	case usecases.ReasonCanceled:
+		return status.FromContextError(ctx.Err()).Err()
+	case usecases.ReasonSlowConsumer:
+		return status.Error(codes.ResourceExhausted, streamErr.Reason)
+	default:
+		return status.Error(codes.Unavailable, streamErr.Reason)
+	}
+}
+
+func (s *grpcEventServer) GetState(ctx context.Context, request *streamlinev1.GetStateRequest) (*streamlinev1.Event, error) {
+	if request.Channel == "" {
+		return nil, status.Error(codes.InvalidArgument, MsgMissingEventID)
+	}
+	if !s.authorizer.Allowed(auth.IdentityFrom(ctx), request.Channel, auth.PermissionRead) {
+		return nil, status.Error(codes.PermissionDenied, MsgForbidden)
+	}
+
+	state, err := s.eventUseCase.GetState(request.Channel)
+	if errors.Is(err, usecases.ErrStateNotFound) {
+		return nil, status.Error(codes.NotFound, MsgStateNotFound)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, MsgUnexpectedErr)
+	}
+
+	return toProtoEvent(*state), nil
+}
+
+// toProtoEvent converts an event to its gRPC message, of which the version is the
+// resume token.
+func toProtoEvent(event entities.Event) *streamlinev1.Event {
+	return &streamlinev1.Event{
+		Channel:     event.Id,
+		Type:        event.Type,
+		Version:     event.Version,
+		Message:     event.Message,
+		Patch:       event.Patch,
+		PatchType:   event.PatchType,
+		Replay:      event.Replay,
+		Publisher:   event.Publisher,
+		Traceparent: event.TraceParent,
+		ResumeToken: event.EventID(),
+	}
+}
+
+// NewGRPCInterceptors returns the interceptors doing for gRPC calls what the trace and
+// auth middlewares do for HTTP requests. The authenticator is skipped when nil.
+func NewGRPCInterceptors(authenticator auth.Authenticator) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	prepare := func(ctx context.Context, method string) (context.Context, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		var traceParent string
+		if values := md.Get(tracing.TraceParentHeader); len(values) > 0 {
+			traceParent = values[0]
+		}
+		ctx = tracing.WithTraceParent(ctx, tracing.Child(traceParent))
+
+		if authenticator == nil {
+			return ctx, nil
+		}
+
+		// Authenticators read HTTP requests, of which metadata are the headers
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, method, nil)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		for name, values := range md {
+			for _, value := range values {
+				r.Header.Add(name, value)
+			}
+		}
+
+		identity, err := authenticator.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, auth.ErrNoCredentials) {
+				log.Printf(errAuthenticate, method, err)
+			}
+			return nil, status.Error(codes.Unauthenticated, MsgUnauthorized)
+		}
+
+		return auth.WithIdentity(ctx, identity), nil
+	}
+
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := prepare(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := prepare(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+
+	return unary, stream
+}
+
+// contextStream is a server stream with the context prepared by the interceptor.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"streamline/internal/entities"
+	"streamline/internal/usecases"
+	"streamline/pkg/auth"
+	"streamline/pkg/sse"
+)
+
+const (
+	MsgInvalidCursor  = "Invalid cursor"
+	MsgInvalidTimeout = "Invalid timeout"
+	MsgPollFailed     = "Poll failed: "
+
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 2 * time.Minute
+
+	// maxPollEvents bounds a batch, the rest being returned by the next poll.
+	maxPollEvents = 100
+	// pollGrace is how long a batch waits for more events once it has one, since
+	// events are handed over one at a time.
+	pollGrace = 50 * time.Millisecond
+
+	// noCursor is below every version, including the version 0 of an empty snapshot.
+	noCursor = -1
+)
+
+// pollBatch is the answer to a poll: the events after the cursor of the request, and
+// the cursor to poll from next.
+type pollBatch struct {
+	Events []entities.Event `json:"events"`
+	Cursor string           `json:"cursor"`
+}
+
+// PollEvent is the long-polling fallback of StreamEvent, for clients behind proxies
+// buffering event streams. It waits up to the timeout for events after the cursor and
+// answers with those available as a batch, an empty one when none arrived in time.
+//
+// Cursors are event IDs, so the cursor of a batch can be sent as the Last-Event-ID of
+// an event stream and the other way around. Without a cursor, or when the events after
+// it are no longer recorded, the batch starts with a snapshot of the state.
+func (h *eventHandler) PollEvent(res Response, req Request) {
+	chID := req.Param("id")
+	if chID == "" {
+		res.Error(MsgMissingEventID, http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(res, req, h.authorizer, chID, auth.PermissionRead) {
+		return
+	}
+
+	options, err := parseStreamOptions(req)
+	if err != nil {
+		res.Error(MsgInvalidStreamOption+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options.LastEventID = req.Query("cursor")
+	if options.LastEventID == "" {
+		options.LastEventID = req.Header(sse.LastEventIDHeader)
+	}
+	cursor := int64(noCursor)
+	if options.LastEventID != "" {
+		if cursor, err = usecases.ParseVersion(options.LastEventID); err != nil {
+			res.Error(MsgInvalidCursor, http.StatusBadRequest)
+			return
+		}
+	}
+
+	timeout := defaultPollTimeout
+	if value := req.Query("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 || timeout > maxPollTimeout {
+			res.Error(MsgInvalidTimeout, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	sub, err := h.eventUseCase.SubscribeAndStreamEvent(ctx, chID, options)
+	if err != nil {
+		res.Error(MsgUnexpectedErr, http.StatusInternalServerError)
+		return
+	}
+	defer sub.Close()
+
+	batch := poll(sub, cursor)
+
+	var streamErr *usecases.StreamError
+	if len(batch.Events) == 0 && errors.As(sub.Err(), &streamErr) && streamErr.Reason != usecases.ReasonCanceled {
+		res.Error(MsgPollFailed+streamErr.Reason, http.StatusServiceUnavailable)
+		return
+	}
+
+	res.SetHeader("Cache-Control", "no-store")
+	if err := res.JSON(batch); err != nil {
+		res.Error(MsgUnexpectedErr, http.StatusInternalServerError)
+		return
+	}
+}
+
+// poll waits for the first event of the subscription after the cursor, then takes the
+// events following it within the grace period. The snapshot of a channel without state
+// is at version 0, so it is only returned to the first poll.
+func poll(sub usecases.Subscription, cursor int64) pollBatch {
+	batch := pollBatch{Events: []entities.Event{}}
+
+	take := func(event entities.Event) {
+		// The stream may start over with a snapshot the client already has
+		if event.Version <= cursor || event.Type == entities.EventTypeSlowConsumer {
+			return
+		}
+		batch.Events = append(batch.Events, event)
+		cursor = event.Version
+	}
+
+	for event := range sub.Events() {
+		take(event)
+		if len(batch.Events) > 0 {
+			break
+		}
+	}
+
+	grace := time.NewTimer(pollGrace)
+	defer grace.Stop()
+
+drain:
+	for len(batch.Events) > 0 && len(batch.Events) < maxPollEvents {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				break drain
+			}
+			take(event)
+		case <-grace.C:
+			break drain
+		}
+	}
+
+	if cursor != noCursor {
+		batch.Cursor = strconv.FormatInt(cursor, 10)
+	}
+	return batch
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+
+	"streamline/pkg/tracing"
+
+	"github.com/gorilla/mux"
+)
+
+// NewTraceMiddleware continues the trace of the traceparent header of the request, or
+// starts a new one, and stores the traceparent of the request in its context so that
+// the events it publishes can be correlated with it.
+func NewTraceMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceParent := tracing.Child(r.Header.Get(tracing.TraceParentHeader))
+			next.ServeHTTP(w, r.WithContext(tracing.WithTraceParent(r.Context(), traceParent)))
+		})
+	}
+}
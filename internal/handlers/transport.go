@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"streamline/internal/usecases"
+	"streamline/pkg/sse"
+
+	"github.com/gorilla/mux"
+)
+
+type (
+	// Request is what the event handlers read of an HTTP request, whichever server
+	// received it.
+	Request interface {
+		// Context carries the identity and the traceparent stored by the middlewares.
+		Context() context.Context
+		// Param returns a parameter of the route, such as the channel ID.
+		Param(name string) string
+		Query(name string) string
+		QueryAll(name string) []string
+		Header(name string) string
+		Body() io.Reader
+		Path() string
+	}
+
+	// Response is how the event handlers answer, whichever server the request came from.
+	Response interface {
+		SetHeader(name, value string)
+		WriteHeader(code int)
+		// Error answers with a plain text error, as http.Error does.
+		Error(message string, code int)
+		JSON(v interface{}) error
+		// Stream answers with the events of the subscription as Server-Sent Events and
+		// closes it once the stream ends, which may be after the handler returned.
+		Stream(sub usecases.Subscription, config sse.Config)
+	}
+
+	// HandlerFunc handles a request independently of the server it is mounted on.
+	HandlerFunc func(res Response, req Request)
+
+	// route is an event route, of which the path parameters are written {name}.
+	route struct {
+		method  string
+		path    string
+		handler HandlerFunc
+	}
+
+	netRequest struct {
+		r *http.Request
+	}
+
+	netResponse struct {
+		w http.ResponseWriter
+		r *http.Request
+	}
+)
+
+// routes lists the routes of the event handler every server mounts.
+func routes(handler EventHandler) []route {
+	return []route{
+		{http.MethodGet, "/api/v1/event/{id}", handler.StreamEvent},
+		{http.MethodPatch, "/api/v1/event/{id}", handler.PatchEvent},
+		{http.MethodDelete, "/api/v1/event/{id}", handler.DeleteEvent},
+		{http.MethodGet, "/api/v1/event/{id}/state", handler.GetState},
+		{http.MethodGet, "/api/v1/event/{id}/poll", handler.PollEvent},
+		{http.MethodGet, "/api/v1/events", handler.StreamEvents},
+		{http.MethodGet, "/api/v1/stats", handler.GetStats},
+	}
+}
+
+// MountNet mounts the event routes on a gorilla/mux router, along with the WebSocket
+// endpoint which is only served over net/http.
+func MountNet(router *mux.Router, handler EventHandler) {
+	for _, route := range routes(handler) {
+		// A parameter spans a single path segment
+		path := strings.NewReplacer("{id}", "{id:[^/]+}").Replace(route.path)
+		router.HandleFunc(path, NetHandler(route.handler)).Methods(route.method)
+	}
+
+	router.HandleFunc("/api/v1/ws", handler.WebSocket).Methods(http.MethodGet)
+}
+
+// NetHandler adapts a handler to net/http, reading the route parameters from gorilla/mux.
+func NetHandler(handler HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(&netResponse{w: w, r: r}, &netRequest{r: r})
+	}
+}
+
+func (req *netRequest) Context() context.Context {
+	return req.r.Context()
+}
+
+func (req *netRequest) Param(name string) string {
+	return mux.Vars(req.r)[name]
+}
+
+func (req *netRequest) Query(name string) string {
+	return req.r.URL.Query().Get(name)
+}
+
+func (req *netRequest) QueryAll(name string) []string {
+	return req.r.URL.Query()[name]
+}
+
+func (req *netRequest) Header(name string) string {
+	return req.r.Header.Get(name)
+}
+
+func (req *netRequest) Body() io.Reader {
+	return req.r.Body
+}
+
+func (req *netRequest) Path() string {
+	return req.r.URL.Path
+}
+
+func (res *netResponse) SetHeader(name, value string) {
+	res.w.Header().Set(name, value)
+}
+
+func (res *netResponse) WriteHeader(code int) {
+	res.w.WriteHeader(code)
+}
+
+func (res *netResponse) Error(message string, code int) {
+	http.Error(res.w, message, code)
+}
+
+func (res *netResponse) JSON(v interface{}) error {
+	res.w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(res.w).Encode(v)
+}
+
+// Stream blocks until the client is gone or the subscription ends.
+func (res *netResponse) Stream(sub usecases.Subscription, config sse.Config) {
+	// Closing releases the upstream subscriptions right away
+	defer sub.Close()
+
+	if err := sse.StreamWithConfig(res.r.Context(), res.w, sub.Events(), config); err != nil {
+		log.Printf(errStreamClient, res.r.URL.Path, err)
+		return
+	}
+
+	if frame, ok := finalFrame(sub); ok {
+		if err := sse.Send(res.w, frame, config); err != nil {
+			log.Printf(errStreamClient, res.r.URL.Path, err)
+		}
+	}
+}
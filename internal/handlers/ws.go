@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"streamline/internal/entities"
+	"streamline/internal/usecases"
+	"streamline/pkg/auth"
+	"streamline/pkg/hub"
+	"streamline/pkg/ws"
+)
+
+// The WebSocket protocol exchanges one JSON object per text message, told apart by its
+// "type". The client sends requests, each with an "id" of its choice echoed in the reply:
+//
+//	{"type":"subscribe","id":"1","channel":"orders.1","mode":"patch","lastEventId":"41","overflow":"disconnect"}
+//	{"type":"unsubscribe","id":"2","channel":"orders.1"}
+//	{"type":"publish","id":"3","channel":"orders.1","patchType":"application/merge-patch+json","patch":{"paid":true},"ifMatch":"\"42\""}
+//
+// Only "channel" is required. A subscribe request takes the stream options of SSE, a
+// publish request the Content-Type and If-Match of a PATCH request, its patch being the
+// new document when patchType is empty. The server answers each request with an ack,
+// carrying the new version of a publish, or with an error with a reason code. A
+// subscribe request is acknowledged once its stream is open, possibly after the replies
+// to the requests that follow it:
+//
+//	{"type":"ack","id":"3","channel":"orders.1","version":43}
+//	{"type":"error","id":"3","channel":"orders.1","reason":"precondition-failed","message":"Precondition failed"}
+//
+// and delivers the events of the subscribed channels, then ends a subscription the
+// server stopped with the reason, e.g. slow-consumer, and the version of the last event
+// delivered, from which the client may subscribe again:
+//
+//	{"type":"event","channel":"orders.1","event":{"id":"orders.1","type":"patch","version":43,...}}
+//	{"type":"end","channel":"orders.1","reason":"slow-consumer","version":43}
+//
+// A client falling behind slows down the delivery of every channel, to which the
+// overflow policy of each subscription then applies as it does to SSE streams. A
+// subscription of which the event cannot be queued within the write timeout is ended
+// as slow-consumer, a client not reading the replies to its requests is disconnected.
+const (
+	wsTypeSubscribe   = "subscribe"
+	wsTypeUnsubscribe = "unsubscribe"
+	wsTypePublish     = "publish"
+	wsTypeAck         = "ack"
+	wsTypeError       = "error"
+	wsTypeEvent       = "event"
+	wsTypeEnd         = "end"
+
+	// Reason codes of the error replies.
+	wsReasonInvalidRequest     = "invalid-request"
+	wsReasonForbidden          = "forbidden"
+	wsReasonTooManyChannels    = "too-many-channels"
+	wsReasonAlreadySubscribed  = "already-subscribed"
+	wsReasonNotSubscribed      = "not-subscribed"
+	wsReasonPreconditionFailed = "precondition-failed"
+	wsReasonUnsupportedPatch   = "unsupported-patch"
+	wsReasonInvalidPatch       = "invalid-patch"
+	wsReasonPatchFailed        = "patch-failed"
+	wsReasonInternal           = "internal"
+
+	MsgUnknownMessageType = "Unknown message type"
+	MsgAlreadySubscribed  = "Already subscribed"
+	MsgNotSubscribed      = "Not subscribed"
+
+	errUpgrade      = "Error upgrading WebSocket connection: %v"
+	errWebSocket    = "Error sending to WebSocket client: %v"
+	errWSSubscribe  = "Error subscribing WebSocket client to channel %s: %v"
+	errWSSubscriber = "Error WebSocket subscription to channel %s ended: %s"
+)
+
+type (
+	// wsRequest is a message of the client.
+	wsRequest struct {
+		Type    string `json:"type"`
+		ID      string `json:"id,omitempty"`
+		Channel string `json:"channel"`
+
+		// Stream options of a subscribe request.
+		Mode        string `json:"mode,omitempty"`
+		LastEventID string `json:"lastEventId,omitempty"`
+		Overflow    string `json:"overflow,omitempty"`
+
+		// Update of a publish request.
+		PatchType string          `json:"patchType,omitempty"`
+		Patch     json.RawMessage `json:"patch,omitempty"`
+		IfMatch   string          `json:"ifMatch,omitempty"`
+	}
+
+	// wsReply is a message of the server.
+	wsReply struct {
+		Type    string          `json:"type"`
+		ID      string          `json:"id,omitempty"`
+		Channel string          `json:"channel,omitempty"`
+		Version int64           `json:"version,omitempty"`
+		Reason  string          `json:"reason,omitempty"`
+		Message string          `json:"message,omitempty"`
+		Event   *entities.Event `json:"event,omitempty"`
+	}
+
+	// wsSession is the state of a WebSocket connection: its subscriptions by channel.
+	wsSession struct {
+		handler  *eventHandler
+		conn     *ws.Conn
+		identity *auth.Identity
+
+		mu   sync.Mutex
+		subs map[string]*wsSubscription
+	}
+
+	// wsSubscription is a subscription of a connection, without a stream while it opens.
+	wsSubscription struct {
+		sub usecases.Subscription
+	}
+)
+
+// WebSocket serves the protocol above on a single connection per client, which can
+// subscribe to and unsubscribe from many channels and publish to them. Credentials are
+// checked once when the connection is opened, permissions on every request.
+func (h *eventHandler) WebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := ws.Upgrade(w, r, h.wsConfig)
+	if err != nil {
+		log.Printf(errUpgrade, err)
+		return
+	}
+	defer conn.Close()
+
+	session := &wsSession{
+		handler:  h,
+		conn:     conn,
+		identity: auth.IdentityFrom(r.Context()),
+		subs:     make(map[string]*wsSubscription),
+	}
+	defer session.closeAll()
+
+	for {
+		var request wsRequest
+		if err := conn.Receive(&request); err != nil {
+			if conn.Err() != nil {
+				return
+			}
+			session.reply(wsReply{Type: wsTypeError, Reason: wsReasonInvalidRequest, Message: MsgCanNotParseRequest})
+			continue
+		}
+
+		session.handle(r.Context(), request)
+	}
+}
+
+// handle answers a request of the client.
+func (s *wsSession) handle(ctx context.Context, request wsRequest) {
+	if request.Channel == "" {
+		s.fail(request, wsReasonInvalidRequest, MsgMissingEventID)
+		return
+	}
+
+	switch request.Type {
+	case wsTypeSubscribe:
+		s.subscribe(ctx, request)
+	case wsTypeUnsubscribe:
+		s.unsubscribe(request)
+	case wsTypePublish:
+		s.publish(ctx, request)
+	default:
+		s.fail(request, wsReasonInvalidRequest, MsgUnknownMessageType)
+	}
+}
+
+func (s *wsSession) subscribe(ctx context.Context, request wsRequest) {
+	if !s.handler.authorizer.Allowed(s.identity, request.Channel, auth.PermissionRead) {
+		s.fail(request, wsReasonForbidden, MsgForbidden)
+		return
+	}
+
+	options := usecases.StreamOptions{
+		Mode:        request.Mode,
+		LastEventID: request.LastEventID,
+	}
+	switch options.Mode {
+	case "", entities.DeliveryDocument, entities.DeliveryPatch:
+	default:
+		s.fail(request, wsReasonInvalidRequest, MsgInvalidStreamOption+errInvalidMode.Error())
+		return
+	}
+	if request.Overflow != "" {
+		policy, err := hub.ParsePolicy(request.Overflow)
+		if err != nil {
+			s.fail(request, wsReasonInvalidRequest, MsgInvalidStreamOption+err.Error())
+			return
+		}
+		options.Overflow = policy
+	}
+
+	// The channel is taken right away, so the requests that follow see the subscription
+	s.mu.Lock()
+	_, subscribed := s.subs[request.Channel]
+	full := len(s.subs) >= maxChannelsPerStream
+	entry := &wsSubscription{}
+	if !subscribed && !full {
+		s.subs[request.Channel] = entry
+	}
+	s.mu.Unlock()
+
+	switch {
+	case subscribed:
+		s.fail(request, wsReasonAlreadySubscribed, MsgAlreadySubscribed)
+	case full:
+		s.fail(request, wsReasonTooManyChannels, MsgTooManyChannels)
+	default:
+		// Opening the stream reads the state from Redis, other requests go on meanwhile
+		go s.open(ctx, request, options, entry)
+	}
+}
+
+// open starts the stream of a subscription taken by subscribe, then forwards its events.
+func (s *wsSession) open(ctx context.Context, request wsRequest, options usecases.StreamOptions, entry *wsSubscription) {
+	sub, err := s.handler.eventUseCase.SubscribeAndStreamEvent(ctx, request.Channel, options)
+	if err != nil {
+		log.Printf(errWSSubscribe, request.Channel, err)
+		s.mu.Lock()
+		if s.subs[request.Channel] == entry {
+			delete(s.subs, request.Channel)
+		}
+		s.mu.Unlock()
+		s.fail(request, wsReasonInternal, MsgUnexpectedErr)
+		return
+	}
+
+	s.mu.Lock()
+	current := s.subs[request.Channel] == entry
+	if current {
+		entry.sub = sub
+	}
+	s.mu.Unlock()
+
+	// Unsubscribed, or the connection closed, while the stream was opening
+	if !current {
+		sub.Close()
+		return
+	}
+
+	// Acknowledge before the first event so the client knows where it starts
+	s.reply(wsReply{Type: wsTypeAck, ID: request.ID, Channel: request.Channel})
+	s.forward(request.Channel, entry, sub)
+}
+
+func (s *wsSession) unsubscribe(request wsRequest) {
+	s.mu.Lock()
+	entry, ok := s.subs[request.Channel]
+	delete(s.subs, request.Channel)
+	var sub usecases.Subscription
+	if ok {
+		sub = entry.sub
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.fail(request, wsReasonNotSubscribed, MsgNotSubscribed)
+		return
+	}
+
+	// A subscription still opening is closed by open once it sees it was removed
+	if sub != nil {
+		sub.Close()
+	}
+	s.reply(wsReply{Type: wsTypeAck, ID: request.ID, Channel: request.Channel})
+}
+
+func (s *wsSession) publish(ctx context.Context, request wsRequest) {
+	if !s.handler.authorizer.Allowed(s.identity, request.Channel, auth.PermissionWrite) {
+		s.fail(request, wsReasonForbidden, MsgForbidden)
+		return
+	}
+
+	patchType := request.PatchType
+	if patchType == "" {
+		patchType = entities.PatchTypeReplace
+	}
+	patch := request.Patch
+	if patch == nil {
+		patch = json.RawMessage("null")
+	}
+
+	var precondition usecases.Precondition
+	if request.IfMatch != "" {
+		versions, wildcard := parseETags(request.IfMatch)
+		if !wildcard && len(versions) == 0 {
+			s.fail(request, wsReasonPreconditionFailed, MsgPreconditionFailed)
+			return
+		}
+		precondition = usecases.Precondition{Versions: versions, Exists: wildcard}
+	}
+
+	event, err := s.handler.eventUseCase.PublishEvent(ctx, request.Channel, patchType, patch, precondition)
+	switch {
+	case errors.Is(err, usecases.ErrPreconditionFailed):
+		s.fail(request, wsReasonPreconditionFailed, MsgPreconditionFailed)
+	case errors.Is(err, usecases.ErrPatchType):
+		s.fail(request, wsReasonUnsupportedPatch, MsgUnsupportedPatch)
+	case errors.Is(err, usecases.ErrInvalidPatch):
+		s.fail(request, wsReasonInvalidPatch, MsgCanNotParseRequest+err.Error())
+	case errors.Is(err, usecases.ErrPatchFailed):
+		s.fail(request, wsReasonPatchFailed, err.Error())
+	case err != nil:
+		s.fail(request, wsReasonInternal, MsgUnexpectedErr)
+	default:
+		s.reply(wsReply{Type: wsTypeAck, ID: request.ID, Channel: request.Channel, Version: event.Version})
+	}
+}
+
+// forward sends the events of a subscription to the client until it ends, waiting for
+// the client to catch up, so the subscription's overflow policy applies while it does.
+// When the server ended it, the client is told why and where to resume from.
+func (s *wsSession) forward(chID string, entry *wsSubscription, sub usecases.Subscription) {
+	var (
+		reason  string
+		version int64
+	)
+
+	for event := range sub.Events() {
+		// The end message tells the client where to resume from
+		if event.Type == entities.EventTypeSlowConsumer {
+			continue
+		}
+
+		err := s.conn.Send(wsReply{Type: wsTypeEvent, Channel: chID, Event: &event})
+		if errors.Is(err, ws.ErrSendTimeout) {
+			reason = usecases.ReasonSlowConsumer
+			sub.Close()
+			break
+		}
+		if err != nil {
+			sub.Close()
+			return
+		}
+		version = event.Version
+	}
+
+	s.mu.Lock()
+	if s.subs[chID] == entry {
+		delete(s.subs, chID)
+	}
+	s.mu.Unlock()
+
+	var streamErr *usecases.StreamError
+	if reason == "" {
+		if !errors.As(sub.Err(), &streamErr) || streamErr.Reason == usecases.ReasonCanceled {
+			return
+		}
+		reason = streamErr.Reason
+	}
+
+	log.Printf(errWSSubscriber, chID, reason)
+	s.reply(wsReply{Type: wsTypeEnd, Channel: chID, Reason: reason, Version: version})
+}
+
+// fail answers a request with an error.
+func (s *wsSession) fail(request wsRequest, reason string, message string) {
+	s.reply(wsReply{
+		Type:    wsTypeError,
+		ID:      request.ID,
+		Channel: request.Channel,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// reply queues a message for the client, disconnecting it when it does not catch up
+// within the write timeout.
+func (s *wsSession) reply(reply wsReply) {
+	err := s.conn.Send(reply)
+	if errors.Is(err, ws.ErrSendTimeout) {
+		log.Printf(errWebSocket, ws.ErrSlowConsumer)
+		s.conn.Disconnect()
+	}
+}
+
+// closeAll ends every subscription of the connection.
+func (s *wsSession) closeAll() {
+	s.mu.Lock()
+	var subs []usecases.Subscription
+	for _, entry := range s.subs {
+		if entry.sub != nil {
+			subs = append(subs, entry.sub)
+		}
+	}
+	s.subs = nil
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
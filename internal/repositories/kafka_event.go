@@ -7,24 +7,47 @@ import (
 )
 
 type (
+	// KafkaEventRepository records the events of each channel in the topic the channel
+	// is routed to, keyed by the channel ID so a channel's events keep their order.
 	KafkaEventRepository interface {
-		Publish(topic string, message interface{}) error
+		Publish(ctx context.Context, chID string, headers map[string]string, message interface{}) error
+		PublishAsync(ctx context.Context, chID string, headers map[string]string, message interface{}, onDelivery func(err error)) error
 		Subscribe(ctx context.Context, topic []string, offsetOption int, consumerGroup string) (<-chan *kafka.Message, error)
+		Broadcast(ctx context.Context, topic []string, offsetOption int) (<-chan *kafka.Message, error)
+		Process(ctx context.Context, topic []string, offsetOption int, consumerGroup string, handler func(ctx context.Context, msg *kafka.Message) error) error
+		Forward(ctx context.Context, topic string, msg *kafka.Message, headers map[string]string) error
+		Topics() []string
+
+		Offsets(chID string, position int64) (map[int32]int64, error)
+		Replay(ctx context.Context, chID string, from, until map[int32]int64) (<-chan *kafka.Message, error)
 	}
 
 	kafkaEventRepository struct {
 		client kafka.Client
+		router kafka.Router
 	}
 )
 
-func NewKafkaEventRepository(client kafka.Client) KafkaEventRepository {
+func NewKafkaEventRepository(client kafka.Client, router kafka.Router) KafkaEventRepository {
 	return &kafkaEventRepository{
 		client: client,
+		router: router,
 	}
 }
 
-func (r *kafkaEventRepository) Publish(topic string, message interface{}) error {
-	return r.client.Produce(topic, message)
+func (r *kafkaEventRepository) Publish(ctx context.Context, chID string, headers map[string]string, message interface{}) error {
+	return r.client.Produce(ctx, r.router.Topic(chID), chID, headers, message)
+}
+
+// PublishAsync queues the message and reports its delivery to onDelivery.
+func (r *kafkaEventRepository) PublishAsync(
+	ctx context.Context,
+	chID string,
+	headers map[string]string,
+	message interface{},
+	onDelivery func(err error),
+) error {
+	return r.client.ProduceAsync(ctx, r.router.Topic(chID), chID, headers, message, onDelivery)
 }
 
 func (r *kafkaEventRepository) Subscribe(
@@ -35,3 +58,89 @@ func (r *kafkaEventRepository) Subscribe(
 ) (<-chan *kafka.Message, error) {
 	return r.client.Consume(ctx, topic, offsetOption, consumerGroup)
 }
+
+// Broadcast reads every message of the topics, whoever else reads them.
+func (r *kafkaEventRepository) Broadcast(ctx context.Context, topic []string, offsetOption int) (<-chan *kafka.Message, error) {
+	return r.client.Broadcast(ctx, topic, offsetOption)
+}
+
+// Process hands the messages of the topics to the handler until ctx is canceled. A message
+// is only committed once handled, one failing is redelivered. The handler's ctx is canceled
+// when its partition is revoked.
+func (r *kafkaEventRepository) Process(
+	ctx context.Context,
+	topic []string,
+	offsetOption int,
+	consumerGroup string,
+	handler func(ctx context.Context, msg *kafka.Message) error,
+) error {
+	config := kafka.HandlerConfig{
+		OffsetOption: offsetOption,
+		AutoCommit:   true,
+	}
+
+	return r.client.ConsumeWithHandler(ctx, topic, consumerGroup, config, func(session kafka.Session, msg *kafka.Message) error {
+		if err := handler(session.Context(), msg); err != nil {
+			return err
+		}
+		session.Mark(msg)
+		return nil
+	})
+}
+
+// Forward produces a consumed message as is to another topic, with the given headers.
+func (r *kafkaEventRepository) Forward(ctx context.Context, topic string, msg *kafka.Message, headers map[string]string) error {
+	return r.client.Produce(ctx, topic, string(msg.Key), headers, msg.Value)
+}
+
+// Topics returns the topics channels are routed to, apart from those named after channels.
+func (r *kafkaEventRepository) Topics() []string {
+	return r.router.Topics()
+}
+
+// Offsets resolves a position in the partitions holding the channel's records: every
+// partition of a topic named after the channel, only the partition its ID hashes to
+// in a topic shared with other channels.
+func (r *kafkaEventRepository) Offsets(chID string, position int64) (map[int32]int64, error) {
+	topic := r.router.Topic(chID)
+
+	offsets, err := r.client.Offsets(topic, position)
+	if err != nil || topic == chID {
+		return offsets, err
+	}
+
+	partition, err := r.client.Partition(topic, chID)
+	if err != nil {
+		return nil, err
+	}
+	return map[int32]int64{partition: offsets[partition]}, nil
+}
+
+// Replay reads the history of the channel between the given offsets of the partitions of
+// its topic, as resolved by Offsets. Messages keyed by another channel are skipped, those
+// without a key are not.
+func (r *kafkaEventRepository) Replay(ctx context.Context, chID string, from, until map[int32]int64) (<-chan *kafka.Message, error) {
+	messages, err := r.client.ConsumePartitions(ctx, r.router.Topic(chID), from, until)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make(chan *kafka.Message)
+	go func() {
+		defer close(filtered)
+
+		for msg := range messages {
+			if len(msg.Key) > 0 && string(msg.Key) != chID {
+				continue
+			}
+
+			select {
+			case filtered <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return filtered, nil
+}
@@ -2,24 +2,81 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"time"
 
 	"streamline/pkg/redis"
 )
 
+const (
+	streamKeyPrefix = "stream:"
+	stateKeyPrefix  = "state:"
+	sourceKeyPrefix = "source:"
+	streamPayload   = "payload"
+
+	// Values of the entries materialized from an external log, locating their record.
+	streamSourceTopic     = "source-topic"
+	streamSourcePartition = "source-partition"
+	streamSourceOffset    = "source-offset"
+)
+
+var (
+	ErrAlreadyApplied = errors.New("source offset already applied")
+)
+
 type (
 	RedisEventRepository interface {
 		Publish(chID string, message interface{}) error
 		Subscribe(ctx context.Context, chID string) (<-chan *redis.Message, error)
+		PSubscribe(ctx context.Context, pattern string) (<-chan *redis.Message, error)
+
+		Range(chID string, fromVersion int64) ([]*redis.StreamMessage, error)
+
+		GetState(chID string, state interface{}) error
+		Update(chID string, fn func(state []byte, lastVersion int64) (*StateUpdate, error)) error
+		Materialize(chID string, source SourceOffset, fn func(state []byte, lastVersion int64) (*StateUpdate, error)) error
+	}
+
+	// SourceOffset locates the record of an external log an update originates from.
+	SourceOffset struct {
+		Topic     string
+		Partition int32
+		Offset    int64
+	}
+
+	// StateUpdate is the outcome of an update of a channel's state.
+	StateUpdate struct {
+		// Version of the event, greater than the last one. It is recorded in the
+		// replay stream under the entry ID "<version>-0".
+		Version int64
+		// State replaces the channel's state, which is removed when nil.
+		State []byte
+		// Message is recorded in the replay stream and published to subscribers.
+		Message []byte
 	}
 
 	redisEventRepository struct {
 		client redis.Client
+		config RedisEventConfig
+	}
+
+	RedisEventConfig struct {
+		// StreamMaxLen caps the length of each channel's replay stream.
+		StreamMaxLen int64
+		// StateTTL expires the latest state of a channel after that long without
+		// updates. States never expire when zero.
+		StateTTL time.Duration
+		// Outbox records every update in the outbox to be relayed to Kafka. It is
+		// disabled when Kafka is the source of the updates.
+		Outbox bool
 	}
 )
 
-func NewRedisEventRepository(client redis.Client) RedisEventRepository {
+func NewRedisEventRepository(client redis.Client, config RedisEventConfig) RedisEventRepository {
 	return &redisEventRepository{
 		client: client,
+		config: config,
 	}
 }
 
@@ -30,3 +87,138 @@ func (r *redisEventRepository) Publish(chID string, message interface{}) error {
 func (r *redisEventRepository) Subscribe(ctx context.Context, chID string) (<-chan *redis.Message, error) {
 	return r.client.Subscribe(ctx, chID)
 }
+
+func (r *redisEventRepository) PSubscribe(ctx context.Context, pattern string) (<-chan *redis.Message, error) {
+	return r.client.PSubscribe(ctx, pattern)
+}
+
+// Range returns the replay stream entries of the channel starting at fromVersion, inclusive.
+// The payload of each entry is available under the "payload" value.
+func (r *redisEventRepository) Range(chID string, fromVersion int64) ([]*redis.StreamMessage, error) {
+	return r.client.XRange(streamKeyPrefix+chID, streamID(fromVersion), "+")
+}
+
+// GetState loads the latest state of the channel into state.
+// It returns redis.ErrNotFound when the channel has no state.
+func (r *redisEventRepository) GetState(chID string, state interface{}) error {
+	return r.client.Get(stateKeyPrefix+chID, state)
+}
+
+// Update atomically replaces the channel's state with the outcome of fn, records it in
+// the replay stream and the outbox, and publishes it, so concurrent writers, including
+// other replicas, never interleave. fn receives the current state, nil when there is
+// none, and the version of the last recorded event. It may run several times on contention.
+func (r *redisEventRepository) Update(chID string, fn func(state []byte, lastVersion int64) (*StateUpdate, error)) error {
+	return r.update(chID, nil, fn)
+}
+
+// Materialize is Update for a record read from an external log. The offset of the last
+// record applied from its partition is kept along with the state, so a record delivered
+// again is rejected with ErrAlreadyApplied instead of being applied twice. The replay
+// stream entry locates the record, see StreamSource.
+func (r *redisEventRepository) Materialize(chID string, source SourceOffset, fn func(state []byte, lastVersion int64) (*StateUpdate, error)) error {
+	return r.update(chID, &source, fn)
+}
+
+func (r *redisEventRepository) update(chID string, source *SourceOffset, fn func(state []byte, lastVersion int64) (*StateUpdate, error)) error {
+	stateKey := stateKeyPrefix + chID
+	streamKey := streamKeyPrefix + chID
+
+	keys := []string{stateKey, streamKey}
+	var sourceKey string
+	if source != nil {
+		sourceKey = sourceKeyPrefix + source.Topic + ":" + strconv.FormatInt(int64(source.Partition), 10)
+		keys = append(keys, sourceKey)
+	}
+
+	return r.client.Transaction(keys, func(tx redis.Tx) error {
+		if source != nil {
+			applied, err := tx.Get(sourceKey)
+			if err != nil && !errors.Is(err, redis.ErrNotFound) {
+				return err
+			}
+			if offset, err := strconv.ParseInt(string(applied), 10, 64); err == nil && offset >= source.Offset {
+				return ErrAlreadyApplied
+			}
+		}
+
+		state, err := tx.Get(stateKey)
+		if err != nil && !errors.Is(err, redis.ErrNotFound) {
+			return err
+		}
+
+		lastID, err := tx.XLast(streamKey)
+		if err != nil {
+			return err
+		}
+
+		update, err := fn(state, streamVersion(lastID))
+		if err != nil {
+			return err
+		}
+
+		values := map[string]interface{}{
+			streamPayload: update.Message,
+		}
+		if source != nil {
+			values[streamSourceTopic] = source.Topic
+			values[streamSourcePartition] = source.Partition
+			values[streamSourceOffset] = source.Offset
+		}
+		tx.XAdd(streamKey, streamID(update.Version), r.config.StreamMaxLen, values)
+		if update.State == nil {
+			tx.Remove(stateKey)
+		} else {
+			tx.Set(stateKey, update.State, r.config.StateTTL)
+		}
+		if source != nil {
+			tx.Set(sourceKey, []byte(strconv.FormatInt(source.Offset, 10)), 0)
+		}
+		tx.Publish(chID, update.Message)
+		if r.config.Outbox {
+			tx.XAdd(outboxKey, "", 0, outboxValues(chID, update.Version, update.Message))
+		}
+
+		return nil
+	})
+}
+
+// streamID returns the replay stream entry ID of a version.
+func streamID(version int64) string {
+	return strconv.FormatInt(version, 10) + "-0"
+}
+
+// streamVersion returns the version recorded under a replay stream entry ID, 0 when empty.
+func streamVersion(id string) int64 {
+	ms, _, err := redis.ParseStreamID(id)
+	if err != nil {
+		return 0
+	}
+	return int64(ms)
+}
+
+// StreamPayload extracts the raw message stored by Update or Materialize from a stream
+// entry.
+func StreamPayload(msg *redis.StreamMessage) string {
+	payload, _ := msg.Values[streamPayload].(string)
+	return payload
+}
+
+// StreamSource returns the record of an external log a stream entry was materialized
+// from, false for the entries of updates made through Update.
+func StreamSource(msg *redis.StreamMessage) (SourceOffset, bool) {
+	topic, _ := msg.Values[streamSourceTopic].(string)
+	partitionValue, _ := msg.Values[streamSourcePartition].(string)
+	offsetValue, _ := msg.Values[streamSourceOffset].(string)
+
+	partition, err := strconv.ParseInt(partitionValue, 10, 32)
+	if err != nil || topic == "" {
+		return SourceOffset{}, false
+	}
+	offset, err := strconv.ParseInt(offsetValue, 10, 64)
+	if err != nil {
+		return SourceOffset{}, false
+	}
+
+	return SourceOffset{Topic: topic, Partition: int32(partition), Offset: offset}, true
+}
@@ -0,0 +1,138 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"streamline/pkg/redis"
+)
+
+const (
+	outboxKey             = "outbox"
+	outboxDeliveredPrefix = "outbox:delivered:"
+	outboxChannel         = "channel"
+	outboxVersion         = "version"
+	outboxPayload         = "payload"
+)
+
+type (
+	// OutboxRepository reads the events recorded by RedisEventRepository.Update in the
+	// same transaction as the channel's state, until they are delivered to Kafka.
+	OutboxRepository interface {
+		Init() error
+		Read(ctx context.Context, consumer string, count int64, block time.Duration) ([]*OutboxEntry, error)
+		Claim(consumer string, minIdle time.Duration, count int64) ([]*OutboxEntry, error)
+
+		Delivered(entry *OutboxEntry) (bool, error)
+		Done(entry *OutboxEntry) error
+	}
+
+	OutboxEntry struct {
+		ID      string
+		Channel string
+		Version int64
+		Payload []byte
+	}
+
+	redisOutboxRepository struct {
+		client redis.Client
+		config RedisOutboxConfig
+	}
+
+	RedisOutboxConfig struct {
+		// Group is the consumer group shared by the relays of every replica.
+		Group string
+		// DeliveredTTL is how long delivered events are remembered, so an entry
+		// retried after being delivered is not sent again.
+		DeliveredTTL time.Duration
+	}
+)
+
+func NewRedisOutboxRepository(client redis.Client, config RedisOutboxConfig) OutboxRepository {
+	return &redisOutboxRepository{
+		client: client,
+		config: config,
+	}
+}
+
+// Init creates the consumer group, and the outbox when missing.
+func (r *redisOutboxRepository) Init() error {
+	return r.client.XGroupCreate(outboxKey, r.config.Group)
+}
+
+// Read returns up to count entries not yet handed to any consumer of the group, waiting
+// at most block for new ones. They stay pending for the consumer until Done.
+func (r *redisOutboxRepository) Read(ctx context.Context, consumer string, count int64, block time.Duration) ([]*OutboxEntry, error) {
+	messages, err := r.client.XReadGroup(ctx, outboxKey, r.config.Group, consumer, count, block)
+	if err != nil {
+		return nil, err
+	}
+	return toOutboxEntries(messages), nil
+}
+
+// Claim takes over up to count entries that have been pending for at least minIdle,
+// whether their delivery failed or their consumer is gone.
+func (r *redisOutboxRepository) Claim(consumer string, minIdle time.Duration, count int64) ([]*OutboxEntry, error) {
+	messages, err := r.client.XClaim(outboxKey, r.config.Group, consumer, minIdle, count)
+	if err != nil {
+		return nil, err
+	}
+	return toOutboxEntries(messages), nil
+}
+
+// Delivered reports whether the entry's event has already been delivered.
+func (r *redisOutboxRepository) Delivered(entry *OutboxEntry) (bool, error) {
+	var delivered bool
+	err := r.client.Get(deliveredKey(entry), &delivered)
+	if errors.Is(err, redis.ErrNotFound) {
+		return false, nil
+	}
+	return delivered, err
+}
+
+// Done remembers the entry's event as delivered, then removes the entry from the outbox.
+func (r *redisOutboxRepository) Done(entry *OutboxEntry) error {
+	if err := r.client.SetWithExpiration(deliveredKey(entry), true, r.config.DeliveredTTL); err != nil {
+		return err
+	}
+
+	if err := r.client.XAck(outboxKey, r.config.Group, entry.ID); err != nil {
+		return err
+	}
+
+	return r.client.XDel(outboxKey, entry.ID)
+}
+
+// deliveredKey is the idempotency key of an entry's event, unique per channel and version.
+func deliveredKey(entry *OutboxEntry) string {
+	return outboxDeliveredPrefix + entry.Channel + ":" + strconv.FormatInt(entry.Version, 10)
+}
+
+// outboxValues are the values of the outbox entry recording a message.
+func outboxValues(chID string, version int64, message []byte) map[string]interface{} {
+	return map[string]interface{}{
+		outboxChannel: chID,
+		outboxVersion: version,
+		outboxPayload: message,
+	}
+}
+
+func toOutboxEntries(messages []*redis.StreamMessage) []*OutboxEntry {
+	entries := make([]*OutboxEntry, 0, len(messages))
+	for _, msg := range messages {
+		channel, _ := msg.Values[outboxChannel].(string)
+		version, _ := msg.Values[outboxVersion].(string)
+		payload, _ := msg.Values[outboxPayload].(string)
+
+		v, _ := strconv.ParseInt(version, 10, 64)
+		entries = append(entries, &OutboxEntry{
+			ID:      msg.ID,
+			Channel: channel,
+			Version: v,
+			Payload: []byte(payload),
+		})
+	}
+	return entries
+}
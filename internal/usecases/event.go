@@ -3,144 +3,595 @@ package usecases
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"streamline/internal/entities"
 	"streamline/internal/repositories"
-	"streamline/pkg/kafka"
+	"streamline/pkg/auth"
+	"streamline/pkg/hub"
 	"streamline/pkg/redis"
+	"streamline/pkg/tracing"
+)
 
-	"github.com/bondzai/gogear/toolbox"
+const (
+	errCtxDone          = "Error Context canceled, stopping event stream for channel %s"
+	errSubscribeChannel = "Error subscribing to channel %s: %v"
+	errChannelClosed    = "Error upstream closed for channel %s"
+	errSlowConsumer     = "Error subscriber too slow, disconnecting from channel %s"
+	errSubscribeRedis   = "Error subscribing to Redis events for channel %s: %v"
+	errRedisClosed      = "Error Redis channel closed for channel %s"
+	errUnmarshalRedis   = "Error unmarshaling Redis message for channel %s: %v"
+	errMarshalMessage   = "Error marshaling message for channel %s: %v"
+	errPublishRedis     = "Error publishing to Redis for channel %s: %v"
+	errPublishKafka     = "Error publishing to Kafka for channel %s: %v"
+	errReplayStream     = "Error reading replay stream for channel %s: %v"
+	errUnmarshalEntry   = "Error unmarshaling replay entry %s for channel %s: %v"
+	errInvalidCursor    = "Error invalid Last-Event-ID %q for channel %s, skipping replay"
+	errReplayTrimmed    = "Error Last-Event-ID %q no longer in replay stream for channel %s, sending snapshot"
+	errGetState         = "Error loading state for channel %s: %v"
+	errSetState         = "Error storing state for channel %s: %v"
+	errPatchGap         = "Error missed events after version %d for channel %s, catching up"
 )
 
+// deletedChannelGrace is how long a stream remembers the version of a deleted channel it
+// only follows through patterns, so the deletion arriving through another pattern is
+// still skipped, before forgetting the channel.
+const deletedChannelGrace = time.Minute
+
+// Sources of truth of the channels' state.
 const (
-	consumerGroupName = "consumerGroup1"
-
-	errCtxDone        = "Error Context canceled, stopping event stream for channel %s"
-	errSubscribeRedis = "Error subscribing to Redis events for channel %s: %v"
-	errSubscribeKafka = "Error subscribing to Kafka events for channel %s: %v"
-	errStreamEvent    = "Error streaming events for channel %s: %v"
-	errRedisClosed    = "Error Redis channel closed for channel %s"
-	errKafkaClosed    = "Error Kafka topic closed for channel %s"
-	errUnmarshalRedis = "Error unmarshaling Redis message for channel %s: %v"
-	errProcessKafka   = "Error processing Kafka message for channel %s: %v"
-	errMarshalMessage = "Error marshaling message for channel %s: %v"
-	errPublishRedis   = "Error publishing to Redis for channel %s: %v"
-	errPublishKafka   = "Error publishing to Kafka for channel %s: %v"
+	// SourceRedis commits updates to Redis, then relays them to Kafka.
+	SourceRedis = "redis"
+	// SourceKafka produces updates to Kafka, then materializes them in Redis.
+	SourceKafka = "kafka"
+)
+
+var (
+	ErrStateNotFound = errors.New("event state not found")
+	ErrInvalidPatch  = errors.New("invalid patch")
+	ErrPatchFailed   = errors.New("patch cannot be applied to the current state")
+	ErrPatchType     = errors.New("unsupported patch type")
+
+	ErrInvalidVersion     = errors.New("invalid version")
+	ErrPreconditionFailed = errors.New("state version does not match")
 )
 
 type (
 	EventUseCase interface {
-		PublishEvent(channel string, message interface{}) error
-		SubscribeAndStreamEvent(ctx context.Context, chID string, eventCh chan<- entities.Event) error
+		PublishEvent(ctx context.Context, chID string, patchType string, patch json.RawMessage, precondition Precondition) (*entities.Event, error)
+		DeleteEvent(ctx context.Context, chID string, precondition Precondition) (*entities.Event, error)
+		GetState(chID string) (*entities.Event, error)
+		SubscribeAndStreamEvent(ctx context.Context, chID string, options StreamOptions) (Subscription, error)
+		SubscribeAndStreamEvents(ctx context.Context, chIDs []string, patterns []string, options StreamOptions) (Subscription, error)
+		Stats() map[string]hub.Stats
+	}
+
+	// Precondition restricts an update to given versions of the channel's state,
+	// mirroring the If-Match header. The zero value matches any state.
+	Precondition struct {
+		// Versions the current state must be at, any version when empty.
+		Versions []int64
+		// Exists requires the channel to have a state, as If-Match: * does.
+		Exists bool
+	}
+
+	// StreamOptions are chosen by each subscriber when it connects.
+	StreamOptions struct {
+		// LastEventID resumes the stream after the given event.
+		LastEventID string
+		// From replays the channel's history from Kafka before the live events,
+		// instead of starting with a snapshot. LastEventID is then ignored.
+		From *ReplayFrom
+		// Overflow is the policy applied when the subscriber falls behind,
+		// the default policy of the hub when empty.
+		Overflow hub.Policy
+		// Mode selects whether patch events carry the resulting document or the patch,
+		// entities.DeliveryDocument when empty. Patch subscribers missing events, e.g.
+		// dropped by the overflow policy, are caught up from the replay stream or sent
+		// a snapshot, so patches always apply to the document they follow.
+		Mode string
+		// Authorize filters the channels delivered through pattern subscriptions.
+		// Every channel is delivered when nil.
+		Authorize func(chID string) bool
 	}
 
 	eventUseCase struct {
 		redisEventRepo repositories.RedisEventRepository
 		kafkaEventRepo repositories.KafkaEventRepository
+		channels       *hub.Hub[entities.Event]
+		patterns       *hub.Hub[entities.Event]
+		source         string
+	}
+
+	EventConfig struct {
+		// SubscriberBufferSize is the capacity of each subscriber's queue in the fan-out hub.
+		SubscriberBufferSize int
+		// Overflow is the default policy for subscribers whose queue is full.
+		Overflow hub.Policy
+		// Source is the source of truth of the channels' state, SourceRedis when empty.
+		Source string
 	}
 )
 
-func NewEventUseCase(redisEventRepo repositories.RedisEventRepository, kafkaEventRepo repositories.KafkaEventRepository) EventUseCase {
-	return &eventUseCase{
+func NewEventUseCase(
+	redisEventRepo repositories.RedisEventRepository,
+	kafkaEventRepo repositories.KafkaEventRepository,
+	config EventConfig,
+) EventUseCase {
+	u := &eventUseCase{
 		redisEventRepo: redisEventRepo,
 		kafkaEventRepo: kafkaEventRepo,
+		source:         config.Source,
 	}
-}
 
-func (u *eventUseCase) SubscribeAndStreamEvent(ctx context.Context, chID string, eventCh chan<- entities.Event) error {
-	redisCh, err := u.redisEventRepo.Subscribe(ctx, chID)
-	if err != nil {
-		log.Printf(errSubscribeRedis, chID, err)
-		return err
+	hubConfig := hub.Config{
+		BufferSize: config.SubscriberBufferSize,
+		Overflow:   config.Overflow,
 	}
+	u.channels = hub.New(u.openChannel, hubConfig)
+	u.patterns = hub.New(u.openPattern, hubConfig)
+
+	return u
+}
 
-	kafkaCh, err := u.kafkaEventRepo.Subscribe(ctx, []string{chID}, kafka.OffsetFromLatest, consumerGroupName)
+// SubscribeAndStreamEvent streams the events of the channel, starting with a snapshot of
+// its current state. When a last event ID is given instead, the events published
+// after it are replayed before switching to live delivery. When a replay position is given,
+// the history recorded in Kafka since then is replayed first, without gaps or duplicates
+// with the live events that follow. The stream runs until ctx is canceled, the subscription
+// is closed or the channel's upstream fails.
+func (u *eventUseCase) SubscribeAndStreamEvent(ctx context.Context, chID string, options StreamOptions) (Subscription, error) {
+	// Subscribe before reading the replay stream so nothing published in between is lost
+	sub, err := u.channels.SubscribeWithPolicy(chID, options.Overflow)
 	if err != nil {
-		log.Printf(errSubscribeKafka, chID, err)
-		return err
+		log.Printf(errSubscribeChannel, chID, err)
+		return nil, err
 	}
 
-	if err := u.streamEvent(ctx, chID, redisCh, kafkaCh, eventCh); err != nil {
-		log.Printf(errStreamEvent, chID, err)
-		return err
+	subscription, ctx := newSubscription(ctx, sub.Close)
+
+	var (
+		history     <-chan entities.Event
+		initial     []entities.Event
+		lastVersion int64
+	)
+	if options.From != nil {
+		history, err = u.history(ctx, chID, options.From)
+		if err != nil {
+			log.Printf(errReplayKafka, chID, err)
+			sub.Close()
+			subscription.cancel(err)
+			return nil, err
+		}
+	} else {
+		initial, lastVersion, err = u.initialEvents(chID, options.LastEventID)
+		if err != nil {
+			sub.Close()
+			subscription.cancel(err)
+			return nil, err
+		}
 	}
 
-	return nil
+	go u.streamEvent(ctx, chID, options.Mode, lastVersion, history, initial, sub, subscription)
+
+	return subscription, nil
 }
 
-func (u *eventUseCase) streamEvent(
-	ctx context.Context,
-	chID string,
-	redisCh <-chan *redis.Message,
-	kafkaCh <-chan *kafka.Message,
-	eventCh chan<- entities.Event,
-) error {
-	errCh := make(chan error, 1)
-
-	// Stream processing function
-	processStreams := func() {
-		defer func() {
-			close(errCh)
-			close(eventCh)
-		}()
+// openChannel opens the single upstream shared by every local subscriber of the
+// channel. It yields the decoded Redis events until ctx is canceled.
+func (u *eventUseCase) openChannel(ctx context.Context, chID string) (<-chan entities.Event, error) {
+	redisCh, err := u.redisEventRepo.Subscribe(ctx, chID)
+	if err != nil {
+		log.Printf(errSubscribeRedis, chID, err)
+		return nil, err
+	}
 
-		var event entities.Event
-		event.Id = chID
-		eventCh <- event
+	upstream := make(chan entities.Event)
+	go func() {
+		defer close(upstream)
 
 		for {
 			select {
 			case <-ctx.Done():
 				log.Printf(errCtxDone, chID)
-				errCh <- ctx.Err()
 				return
 
 			case msg, ok := <-redisCh:
 				if !ok {
 					log.Printf(errRedisClosed, chID)
-					errCh <- nil
 					return
 				}
 
-				event, err := u.processRedisMessage(msg, event)
+				// A malformed message is skipped rather than ending the stream
+				event, err := u.processRedisMessage(msg, entities.Event{Id: chID})
 				if err != nil {
 					log.Printf(errUnmarshalRedis, chID, err)
-					errCh <- err
+					continue
+				}
+
+				select {
+				case upstream <- *event:
+				case <-ctx.Done():
 					return
 				}
-				eventCh <- *event
+			}
+		}
+	}()
 
-			case msg, ok := <-kafkaCh:
-				if !ok {
-					log.Printf(errKafkaClosed, chID)
-					errCh <- nil
+	return upstream, nil
+}
+
+// openPattern opens the single upstream shared by every local subscriber of the
+// pattern. It yields the decoded events of every matching channel until ctx is canceled.
+func (u *eventUseCase) openPattern(ctx context.Context, pattern string) (<-chan entities.Event, error) {
+	redisCh, err := u.redisEventRepo.PSubscribe(ctx, pattern)
+	if err != nil {
+		log.Printf(errSubscribeRedis, pattern, err)
+		return nil, err
+	}
+
+	upstream := make(chan entities.Event)
+	go func() {
+		defer close(upstream)
+
+		for msg := range redisCh {
+			event, err := u.processRedisMessage(msg, entities.Event{Id: msg.Channel})
+			if err != nil {
+				log.Printf(errUnmarshalRedis, msg.Channel, err)
+				continue
+			}
+
+			select {
+			case upstream <- *event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return upstream, nil
+}
+
+// SubscribeAndStreamEvents streams the events of several channels and of every channel
+// matching the patterns. Each event carries the channel it originates from. Resuming from
+// a last event ID is not supported since cursors are per channel.
+func (u *eventUseCase) SubscribeAndStreamEvents(
+	ctx context.Context,
+	chIDs []string,
+	patterns []string,
+	options StreamOptions,
+) (Subscription, error) {
+	subs := make([]*hub.Subscriber[entities.Event], 0, len(chIDs)+len(patterns))
+	closeAll := func() {
+		for _, sub := range subs {
+			sub.Close()
+		}
+	}
+
+	for _, chID := range chIDs {
+		sub, err := u.channels.SubscribeWithPolicy(chID, options.Overflow)
+		if err != nil {
+			log.Printf(errSubscribeChannel, chID, err)
+			closeAll()
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	for _, pattern := range patterns {
+		sub, err := u.patterns.SubscribeWithPolicy(pattern, options.Overflow)
+		if err != nil {
+			log.Printf(errSubscribeChannel, pattern, err)
+			closeAll()
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	subscription, ctx := newSubscription(ctx, closeAll)
+	go u.mergeEvents(ctx, chIDs, options, subs, subscription)
+
+	return subscription, nil
+}
+
+// mergeEvents forwards the events of every subscriber to the subscription until it is
+// canceled or one of the subscribers ends, then ends it. An event reaching the client
+// through both a channel and a pattern is only delivered once. Channels only matched by
+// patterns are forgotten a while after they are deleted, so the versions tracked stay
+// bounded by the channels alive.
+func (u *eventUseCase) mergeEvents(
+	ctx context.Context,
+	chIDs []string,
+	options StreamOptions,
+	subs []*hub.Subscriber[entities.Event],
+	subscription *subscription,
+) {
+	// Ending the subscription cancels ctx, which stops the forwarding goroutines
+	merged := make(chan entities.Event)
+	ended := make(chan *hub.Subscriber[entities.Event], len(subs))
+
+	for _, sub := range subs {
+		go func(sub *hub.Subscriber[entities.Event]) {
+			for {
+				select {
+				case event, ok := <-sub.C():
+					if !ok {
+						ended <- sub
+						return
+					}
+
+					select {
+					case merged <- event:
+					case <-ctx.Done():
+						return
+					}
+
+				case <-ctx.Done():
 					return
 				}
+			}
+		}(sub)
+	}
+
+	send := func(event entities.Event) bool {
+		if !subscription.send(ctx, event.ForDelivery(options.Mode)) {
+			subscription.canceled(ctx)
+			return false
+		}
+		return true
+	}
+
+	prune := time.NewTicker(deletedChannelGrace)
+	defer prune.Stop()
+
+	// Deletion time of the channels only matched by patterns, and forgotten after the grace
+	deleted := make(map[string]time.Time)
+	lastVersions := make(map[string]int64)
+	for _, chID := range chIDs {
+		snapshot, err := u.snapshot(chID)
+		if err != nil {
+			log.Printf(errGetState, chID, err)
+			subscription.end(ReasonStateUnavailable, err)
+			return
+		}
+
+		if !send(*snapshot) {
+			return
+		}
+		lastVersions[chID] = snapshot.Version
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			subscription.canceled(ctx)
+			return
+
+		case sub := <-ended:
+			if sub.Err() == hub.ErrSlowConsumer {
+				log.Printf(errSlowConsumer, strings.Join(chIDs, ","))
+				if send(entities.Event{Type: entities.EventTypeSlowConsumer}) {
+					subscription.end(ReasonSlowConsumer, sub.Err())
+				}
+				return
+			}
+			log.Printf(errChannelClosed, strings.Join(chIDs, ","))
+			subscription.end(ReasonUpstreamClosed, ErrUpstreamClosed)
+			return
+
+		case event := <-merged:
+			if options.Authorize != nil && !options.Authorize(event.Id) {
+				continue
+			}
+			lastVersion, known := lastVersions[event.Id]
+			if event.Version <= lastVersion {
+				continue
+			}
 
-				if err := u.processKafkaMessage(msg); err != nil {
-					log.Printf(errProcessKafka, chID, err)
-					errCh <- err
+			// Patches only apply to the document they follow: channels matched by a
+			// pattern start with a snapshot, and dropped events are caught up on
+			if options.Mode == entities.DeliveryPatch && (!known || event.Version > lastVersion+1) {
+				var missed []entities.Event
+				var err error
+				if known {
+					log.Printf(errPatchGap, lastVersion, event.Id)
+					missed, err = u.missedEvents(event.Id, lastVersion)
+				} else {
+					var snapshot *entities.Event
+					if snapshot, err = u.snapshot(event.Id); err == nil {
+						missed = []entities.Event{*snapshot}
+					}
+				}
+				if err != nil {
+					log.Printf(errReplayStream, event.Id, err)
+					subscription.end(ReasonReplayFailed, err)
 					return
 				}
+
+				for _, missedEvent := range missed {
+					if !send(missedEvent) {
+						return
+					}
+					lastVersion = max(lastVersion, missedEvent.Version)
+				}
+				lastVersions[event.Id] = lastVersion
+				if event.Version <= lastVersion {
+					continue
+				}
+			}
+			lastVersions[event.Id] = event.Version
+			if !slices.Contains(chIDs, event.Id) {
+				if event.Type == entities.EventTypeDeleted {
+					deleted[event.Id] = time.Now()
+				} else {
+					delete(deleted, event.Id)
+				}
+			}
+
+			if !send(event) {
+				return
+			}
+
+		case now := <-prune.C:
+			for chID, at := range deleted {
+				if now.Sub(at) >= deletedChannelGrace {
+					delete(deleted, chID)
+					delete(lastVersions, chID)
+				}
 			}
 		}
 	}
+}
 
-	go processStreams()
+// streamEvent delivers the history, then the initial events, then the live events of
+// the channel to the subscription, skipping those already delivered, and ends it with
+// the reason the stream stopped.
+func (u *eventUseCase) streamEvent(
+	ctx context.Context,
+	chID string,
+	mode string,
+	lastVersion int64,
+	history <-chan entities.Event,
+	initial []entities.Event,
+	sub *hub.Subscriber[entities.Event],
+	subscription *subscription,
+) {
+	send := func(event entities.Event) bool {
+		if !subscription.send(ctx, event.ForDelivery(mode)) {
+			log.Printf(errCtxDone, chID)
+			subscription.canceled(ctx)
+			return false
+		}
+		return true
+	}
+
+	if history != nil {
+		for event := range history {
+			// Events produced to Kafka without a version are sent as they are
+			if event.Version != 0 && event.Version <= lastVersion {
+				continue
+			}
+			if !send(event) {
+				return
+			}
+			lastVersion = max(lastVersion, event.Version)
+		}
+
+		// Kafka lags behind Redis by the relay, the replay stream holds the rest. With no
+		// record replayed there is nothing to follow on from, the snapshot stands for it
+		if u.source != SourceKafka && ctx.Err() == nil {
+			var err error
+			if lastVersion > 0 {
+				initial, err = u.catchUp(chID, lastVersion)
+			} else {
+				var snapshot *entities.Event
+				if snapshot, err = u.snapshot(chID); err == nil {
+					initial = []entities.Event{*snapshot}
+				}
+			}
+			if err != nil {
+				log.Printf(errReplayStream, chID, err)
+				subscription.end(ReasonReplayFailed, err)
+				return
+			}
+		}
+	}
 
-	select {
-	case err := <-errCh:
-		return err
+	for _, event := range initial {
+		if !send(event) {
+			return
+		}
+		if event.Version > lastVersion {
+			lastVersion = event.Version
+		}
+	}
 
-	case <-ctx.Done():
-		return ctx.Err()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf(errCtxDone, chID)
+			subscription.canceled(ctx)
+			return
 
-	default:
-		return nil
+		case event, ok := <-sub.C():
+			if !ok && sub.Err() == hub.ErrSlowConsumer {
+				// Let the client know why it was cut off, it resumes with Last-Event-ID
+				log.Printf(errSlowConsumer, chID)
+				if send(entities.Event{Id: chID, Type: entities.EventTypeSlowConsumer, Version: lastVersion}) {
+					subscription.end(ReasonSlowConsumer, sub.Err())
+				}
+				return
+			}
+			if !ok {
+				log.Printf(errChannelClosed, chID)
+				subscription.end(ReasonUpstreamClosed, ErrUpstreamClosed)
+				return
+			}
+
+			// Skip live events already covered by the snapshot or the replay
+			if event.Version <= lastVersion {
+				continue
+			}
+
+			// The hub dropped events, without which patches no longer apply
+			if mode == entities.DeliveryPatch && event.Version > lastVersion+1 {
+				log.Printf(errPatchGap, lastVersion, chID)
+				missed, err := u.missedEvents(chID, lastVersion)
+				if err != nil {
+					log.Printf(errReplayStream, chID, err)
+					subscription.end(ReasonReplayFailed, err)
+					return
+				}
+				for _, event := range missed {
+					if !send(event) {
+						return
+					}
+					lastVersion = max(lastVersion, event.Version)
+				}
+				if event.Version <= lastVersion {
+					continue
+				}
+			}
+
+			if !send(event) {
+				return
+			}
+			lastVersion = event.Version
+		}
 	}
 }
 
+// missedEvents returns the events committed to the channel after the given version, or
+// a snapshot of its state when the replay stream no longer holds all of them.
+func (u *eventUseCase) missedEvents(chID string, lastVersion int64) ([]entities.Event, error) {
+	events, err := u.rangeEvents(chID, lastVersion+1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) > 0 && events[0].Version == lastVersion+1 {
+		return events, nil
+	}
+
+	snapshot, err := u.snapshot(chID)
+	if err != nil {
+		return nil, err
+	}
+	return []entities.Event{*snapshot}, nil
+}
+
+// Stats returns the fan-out counters of every channel with subscribers, including
+// dropped events.
+func (u *eventUseCase) Stats() map[string]hub.Stats {
+	return u.channels.Stats()
+}
+
 func (u *eventUseCase) processRedisMessage(msg *redis.Message, event entities.Event) (*entities.Event, error) {
 	if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
 		return nil, err
@@ -149,27 +600,378 @@ func (u *eventUseCase) processRedisMessage(msg *redis.Message, event entities.Ev
 	return &event, nil
 }
 
-func (u *eventUseCase) processKafkaMessage(msg *kafka.Message) error {
-	toolbox.PPrint(msg)
-	return nil
+// initialEvents returns what a new subscriber receives before live events, along with
+// the version after which live events are new to it. A resuming client gets the events
+// it missed from the replay stream, any other client a snapshot of the current state.
+func (u *eventUseCase) initialEvents(chID string, lastEventID string) ([]entities.Event, int64, error) {
+	if lastEventID != "" {
+		replay, lastVersion, ok, err := u.replayEvents(chID, lastEventID)
+		if err != nil {
+			log.Printf(errReplayStream, chID, err)
+			return nil, 0, err
+		}
+		if ok {
+			return replay, lastVersion, nil
+		}
+	}
+
+	snapshot, err := u.snapshot(chID)
+	if err != nil {
+		log.Printf(errGetState, chID, err)
+		return nil, 0, err
+	}
+
+	return []entities.Event{*snapshot}, snapshot.Version, nil
+}
+
+// replayEvents loads the events recorded in the channel's replay stream after the version
+// given as lastEventID. It reports false when the stream no longer holds that version,
+// since events may be missing.
+func (u *eventUseCase) replayEvents(chID string, lastEventID string) ([]entities.Event, int64, bool, error) {
+	lastVersion, err := ParseVersion(lastEventID)
+	if err != nil {
+		log.Printf(errInvalidCursor, lastEventID, chID)
+		return nil, 0, false, nil
+	}
+
+	events, err := u.rangeEvents(chID, lastVersion)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if len(events) == 0 || events[0].Version != lastVersion {
+		log.Printf(errReplayTrimmed, lastEventID, chID)
+		return nil, 0, false, nil
+	}
+
+	return events[1:], lastVersion, true, nil
 }
 
-func (u *eventUseCase) PublishEvent(chID string, message interface{}) error {
-	jsonMessage, err := json.Marshal(message)
+// rangeEvents loads the events recorded in the channel's replay stream from the given
+// version, inclusive.
+func (u *eventUseCase) rangeEvents(chID string, fromVersion int64) ([]entities.Event, error) {
+	entries, err := u.redisEventRepo.Range(chID, fromVersion)
 	if err != nil {
-		log.Printf(errMarshalMessage, chID, err)
-		return err
+		return nil, err
+	}
+
+	events := make([]entities.Event, 0, len(entries))
+	for _, entry := range entries {
+		var event entities.Event
+		if err := json.Unmarshal([]byte(repositories.StreamPayload(entry)), &event); err != nil {
+			log.Printf(errUnmarshalEntry, entry.ID, chID, err)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// ParseVersion parses a version sent back by a client, e.g. as Last-Event-ID.
+func ParseVersion(value string) (int64, error) {
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version < 0 {
+		return 0, ErrInvalidVersion
+	}
+	return version, nil
+}
+
+// snapshot returns the current state of the channel as a snapshot event,
+// an empty one when the channel has no state.
+func (u *eventUseCase) snapshot(chID string) (*entities.Event, error) {
+	event, err := u.GetState(chID)
+	if errors.Is(err, ErrStateNotFound) {
+		return &entities.Event{Id: chID, Type: entities.EventTypeSnapshot}, nil
+	}
+
+	return event, err
+}
+
+// GetState returns the latest state of the channel.
+func (u *eventUseCase) GetState(chID string) (*entities.Event, error) {
+	var event entities.Event
+	if err := u.redisEventRepo.GetState(chID, &event); err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			return nil, ErrStateNotFound
+		}
+		return nil, err
+	}
+
+	event.Id = chID
+	event.Type = entities.EventTypeSnapshot
+
+	return &event, nil
+}
+
+// PublishEvent applies the patch to the channel's state and broadcasts the outcome.
+// The state is checked against the precondition, patched and written atomically, so
+// concurrent patches never overwrite each other. When Kafka is the source, the patch
+// is checked against the current state before being produced and against the state it
+// is applied to when materialized, and the returned event has no version until then.
+// The event carries the identity of the publisher and the trace context found in ctx.
+func (u *eventUseCase) PublishEvent(
+	ctx context.Context,
+	chID string,
+	patchType string,
+	patch json.RawMessage,
+	precondition Precondition,
+) (*entities.Event, error) {
+	publisher, traceParent := metadata(ctx)
+	next := stamped(publisher, traceParent, patchEvent(chID, patchType, patch))
+
+	if u.source == SourceKafka {
+		return u.produce(ctx, chID, precondition, next, &entities.Event{
+			Id:          chID,
+			Type:        entities.EventTypePatch,
+			Patch:       patch,
+			PatchType:   patchType,
+			Publisher:   publisher,
+			TraceParent: traceParent,
+		})
+	}
+
+	return updateState(u.redisEventRepo, chID, precondition, nil, next)
+}
+
+// DeleteEvent removes the channel's state and notifies subscribers.
+func (u *eventUseCase) DeleteEvent(ctx context.Context, chID string, precondition Precondition) (*entities.Event, error) {
+	publisher, traceParent := metadata(ctx)
+	next := stamped(publisher, traceParent, deleteEvent(chID))
+
+	if u.source == SourceKafka {
+		return u.produce(ctx, chID, precondition, next, &entities.Event{
+			Id:          chID,
+			Type:        entities.EventTypeDeleted,
+			Publisher:   publisher,
+			TraceParent: traceParent,
+		})
+	}
+
+	return updateState(u.redisEventRepo, chID, precondition, nil, next)
+}
+
+// produce checks the record against the current state of the channel, then produces it
+// to Kafka, from which MaterializerUseCase applies it. The check is only indicative,
+// the state may change before the record is materialized, so the precondition travels
+// with the record and is enforced again when it is.
+func (u *eventUseCase) produce(
+	ctx context.Context,
+	chID string,
+	precondition Precondition,
+	next func(current *entities.Event) (*entities.Event, error),
+	record *entities.Event,
+) (*entities.Event, error) {
+	current, err := u.GetState(chID)
+	if err != nil && !errors.Is(err, ErrStateNotFound) {
+		log.Printf(errGetState, chID, err)
+		return nil, err
+	}
+
+	exists := err == nil
+	if !exists {
+		current = &entities.Event{Id: chID}
 	}
 
-	if err := u.redisEventRepo.Publish(chID, jsonMessage); err != nil {
-		log.Printf(errPublishRedis, chID, err)
-		return err
+	if !precondition.matches(exists, current.Version) {
+		return nil, ErrPreconditionFailed
 	}
 
-	if err := u.kafkaEventRepo.Publish(chID, message); err != nil {
+	if _, err := next(current); err != nil {
+		return nil, err
+	}
+
+	headers := record.Headers()
+	if value := precondition.header(); value != "" {
+		headers[entities.HeaderIfMatch] = value
+	}
+
+	if err := u.kafkaEventRepo.Publish(ctx, chID, headers, record); err != nil {
 		log.Printf(errPublishKafka, chID, err)
-		return err
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// patchEvent derives a patch event from the current state of the channel.
+func patchEvent(chID string, patchType string, patch json.RawMessage) func(current *entities.Event) (*entities.Event, error) {
+	return func(current *entities.Event) (*entities.Event, error) {
+		document, err := applyPatch(current.Message, patchType, patch)
+		if err != nil {
+			return nil, err
+		}
+
+		return &entities.Event{
+			Id:        chID,
+			Type:      entities.EventTypePatch,
+			Message:   document,
+			Patch:     patch,
+			PatchType: patchType,
+		}, nil
+	}
+}
+
+// deleteEvent derives the event removing the state of the channel.
+func deleteEvent(chID string) func(current *entities.Event) (*entities.Event, error) {
+	return func(current *entities.Event) (*entities.Event, error) {
+		return &entities.Event{
+			Id:   chID,
+			Type: entities.EventTypeDeleted,
+		}, nil
+	}
+}
+
+// stamped wraps next so the events it derives carry the identity of their publisher
+// and the trace context they were published in, when known.
+func stamped(publisher, traceParent string, next func(current *entities.Event) (*entities.Event, error)) func(current *entities.Event) (*entities.Event, error) {
+	return func(current *entities.Event) (*entities.Event, error) {
+		event, err := next(current)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Publisher = publisher
+		event.TraceParent = traceParent
+		return event, nil
+	}
+}
+
+// metadata returns the subject of the caller and the traceparent carried by ctx.
+func metadata(ctx context.Context) (publisher string, traceParent string) {
+	if identity := auth.IdentityFrom(ctx); identity != nil {
+		publisher = identity.Subject
+	}
+	return publisher, tracing.TraceParentFrom(ctx)
+}
+
+// updateState derives the next version of the channel from its current state, then
+// atomically records it in the replay stream and as the latest state, and fans it out
+// to live subscribers. When Redis is the source, the same transaction records it in the
+// outbox, from which RelayUseCase delivers it to Kafka. When Kafka is the source, the
+// record the event is materialized from is given so it is never applied twice.
+func updateState(
+	redisEventRepo repositories.RedisEventRepository,
+	chID string,
+	precondition Precondition,
+	source *repositories.SourceOffset,
+	next func(current *entities.Event) (*entities.Event, error),
+) (*entities.Event, error) {
+	var event *entities.Event
+
+	fn := func(state []byte, lastVersion int64) (*repositories.StateUpdate, error) {
+		current := entities.Event{Id: chID}
+		if state != nil {
+			if err := json.Unmarshal(state, &current); err != nil {
+				log.Printf(errGetState, chID, err)
+				return nil, err
+			}
+		}
+
+		if !precondition.matches(state != nil, current.Version) {
+			return nil, ErrPreconditionFailed
+		}
+
+		var err error
+		if event, err = next(&current); err != nil {
+			return nil, err
+		}
+
+		// The replay stream outlives deleted and expired states, so it keeps versions increasing
+		event.Version = max(lastVersion, current.Version) + 1
+
+		message, err := json.Marshal(event)
+		if err != nil {
+			log.Printf(errMarshalMessage, chID, err)
+			return nil, err
+		}
+
+		update := &repositories.StateUpdate{
+			Version: event.Version,
+			Message: message,
+		}
+
+		// The state is the latest document, patches only matter to live subscribers
+		if event.Type != entities.EventTypeDeleted {
+			stored := event.ForDelivery(entities.DeliveryDocument)
+			if update.State, err = json.Marshal(stored); err != nil {
+				log.Printf(errMarshalMessage, chID, err)
+				return nil, err
+			}
+		}
+
+		return update, nil
+	}
+
+	var err error
+	if source == nil {
+		err = redisEventRepo.Update(chID, fn)
+	} else {
+		err = redisEventRepo.Materialize(chID, *source, fn)
+	}
+	if err != nil {
+		if !isPatchError(err) && !errors.Is(err, ErrPreconditionFailed) && !errors.Is(err, repositories.ErrAlreadyApplied) {
+			log.Printf(errPublishRedis, chID, err)
+		}
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// matches reports whether a state, which may not exist, at the given version satisfies
+// the precondition.
+func (p Precondition) matches(exists bool, version int64) bool {
+	if p.Exists && !exists {
+		return false
 	}
 
-	return nil
+	if len(p.Versions) == 0 {
+		return true
+	}
+
+	for _, expected := range p.Versions {
+		if exists && expected == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// header encodes the precondition as the value of the entities.HeaderIfMatch header of
+// a record, empty when it matches any state.
+func (p Precondition) header() string {
+	if len(p.Versions) == 0 {
+		if p.Exists {
+			return "*"
+		}
+		return ""
+	}
+
+	versions := make([]string, len(p.Versions))
+	for i, version := range p.Versions {
+		versions[i] = strconv.FormatInt(version, 10)
+	}
+	return strings.Join(versions, ",")
+}
+
+// preconditionFrom decodes the entities.HeaderIfMatch header of a record.
+func preconditionFrom(header string) (Precondition, error) {
+	switch header {
+	case "":
+		return Precondition{}, nil
+	case "*":
+		return Precondition{Exists: true}, nil
+	}
+
+	var precondition Precondition
+	for _, value := range strings.Split(header, ",") {
+		version, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return Precondition{}, fmt.Errorf("%w: %s", ErrInvalidVersion, value)
+		}
+		precondition.Versions = append(precondition.Versions, version)
+	}
+	return precondition, nil
 }
@@ -0,0 +1,191 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"streamline/internal/entities"
+	"streamline/internal/repositories"
+	"streamline/pkg/kafka"
+	"streamline/pkg/redis"
+)
+
+// receiveTimeout bounds the wait for an event or the end of a subscription.
+const receiveTimeout = 2 * time.Second
+
+type (
+	// fakeRedisEventRepo keeps the states and replay streams in memory. Its upstreams
+	// are fed by the tests, the methods the tests do not need are left unimplemented.
+	fakeRedisEventRepo struct {
+		repositories.RedisEventRepository
+
+		mu        sync.Mutex
+		states    map[string]entities.Event
+		streams   map[string][]*redis.StreamMessage
+		upstreams map[string]chan *redis.Message
+		rangeErr  error
+	}
+
+	// fakeKafkaEventRepo replays the records of a single partition of a topic.
+	fakeKafkaEventRepo struct {
+		repositories.KafkaEventRepository
+
+		topic   string
+		records []*kafka.Message
+	}
+)
+
+func newFakeRedisEventRepo() *fakeRedisEventRepo {
+	return &fakeRedisEventRepo{
+		states:    make(map[string]entities.Event),
+		streams:   make(map[string][]*redis.StreamMessage),
+		upstreams: make(map[string]chan *redis.Message),
+	}
+}
+
+// commit records the event as the latest state and in the replay stream, along with
+// the record it was materialized from when source is set.
+func (r *fakeRedisEventRepo) commit(event entities.Event, source *repositories.SourceOffset) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payload, _ := json.Marshal(event)
+	values := map[string]interface{}{"payload": string(payload)}
+	if source != nil {
+		values["source-topic"] = source.Topic
+		values["source-partition"] = strconv.FormatInt(int64(source.Partition), 10)
+		values["source-offset"] = strconv.FormatInt(source.Offset, 10)
+	}
+
+	r.streams[event.Id] = append(r.streams[event.Id], &redis.StreamMessage{
+		ID:     strconv.FormatInt(event.Version, 10) + "-0",
+		Values: values,
+	})
+	r.states[event.Id] = event.ForDelivery(entities.DeliveryDocument)
+}
+
+// upstream returns the channel feeding the live events of chID.
+func (r *fakeRedisEventRepo) upstream(chID string) chan *redis.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, ok := r.upstreams[chID]
+	if !ok {
+		ch = make(chan *redis.Message, 16)
+		r.upstreams[chID] = ch
+	}
+	return ch
+}
+
+// publish sends a live event to the subscribers of its channel.
+func (r *fakeRedisEventRepo) publish(event entities.Event) {
+	payload, _ := json.Marshal(event)
+	r.upstream(event.Id) <- &redis.Message{Channel: event.Id, Payload: string(payload)}
+}
+
+func (r *fakeRedisEventRepo) Subscribe(ctx context.Context, chID string) (<-chan *redis.Message, error) {
+	return r.upstream(chID), nil
+}
+
+func (r *fakeRedisEventRepo) Range(chID string, fromVersion int64) ([]*redis.StreamMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rangeErr != nil {
+		return nil, r.rangeErr
+	}
+
+	var entries []*redis.StreamMessage
+	for _, entry := range r.streams[chID] {
+		ms, _, _ := redis.ParseStreamID(entry.ID)
+		if int64(ms) >= fromVersion {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (r *fakeRedisEventRepo) GetState(chID string, state interface{}) error {
+	r.mu.Lock()
+	event, ok := r.states[chID]
+	r.mu.Unlock()
+
+	if !ok {
+		return redis.ErrNotFound
+	}
+	*state.(*entities.Event) = event
+	return nil
+}
+
+func (r *fakeKafkaEventRepo) Offsets(chID string, position int64) (map[int32]int64, error) {
+	if position == kafka.OffsetNewest {
+		return map[int32]int64{0: int64(len(r.records))}, nil
+	}
+	return map[int32]int64{0: 0}, nil
+}
+
+func (r *fakeKafkaEventRepo) Replay(ctx context.Context, chID string, from, until map[int32]int64) (<-chan *kafka.Message, error) {
+	records := make(chan *kafka.Message, len(r.records))
+	for _, msg := range r.records[from[0]:until[0]] {
+		records <- msg
+	}
+	close(records)
+	return records, nil
+}
+
+// record appends a record of the channel to the partition and returns its coordinates.
+func (r *fakeKafkaEventRepo) record(chID string, event entities.Event) *repositories.SourceOffset {
+	value, _ := json.Marshal(event)
+	msg := &kafka.Message{
+		Topic:  r.topic,
+		Offset: int64(len(r.records)),
+		Key:    []byte(chID),
+		Value:  value,
+	}
+	r.records = append(r.records, msg)
+	return &repositories.SourceOffset{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+}
+
+// receive returns the next event of the subscription, failing the test when it ends.
+func receive(t *testing.T, sub Subscription) entities.Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("subscription ended early: %v", sub.Err())
+		}
+		return event
+	case <-time.After(receiveTimeout):
+		t.Fatal("no event received")
+		return entities.Event{}
+	}
+}
+
+// endReason waits for the subscription to end, skipping its remaining events, and
+// returns the reason it ended with.
+func endReason(t *testing.T, sub Subscription) string {
+	t.Helper()
+
+	timeout := time.After(receiveTimeout)
+	for {
+		select {
+		case _, ok := <-sub.Events():
+			if ok {
+				continue
+			}
+			streamErr, ok := sub.Err().(*StreamError)
+			if !ok {
+				t.Fatalf("subscription ended with %v, expected a StreamError", sub.Err())
+			}
+			return streamErr.Reason
+		case <-timeout:
+			t.Fatal("subscription did not end")
+			return ""
+		}
+	}
+}
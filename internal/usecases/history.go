@@ -0,0 +1,185 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"streamline/internal/entities"
+	"streamline/internal/repositories"
+	"streamline/pkg/kafka"
+)
+
+const (
+	errReplayKafka     = "Error replaying Kafka history for channel %s: %v"
+	errUnmarshalRecord = "Error unmarshaling Kafka record %d/%d for channel %s: %v"
+	errHistoryGap      = "Error replay stream no longer follows version %d for channel %s, sending snapshot"
+
+	replayFromEarliest = "earliest"
+)
+
+var (
+	ErrInvalidReplayFrom = errors.New("invalid replay position, expected earliest, an offset or an RFC 3339 time")
+)
+
+// ReplayFrom is where the history of a channel is replayed from Kafka.
+type ReplayFrom struct {
+	// Offset the replay starts at in the partition holding the channel's records, or in
+	// every partition of a topic named after the channel, unless Time is set.
+	// kafka.OffsetOldest replays the whole history still retained.
+	Offset int64
+	// Time replays the events produced since then.
+	Time time.Time
+}
+
+// ParseReplayFrom parses "earliest", an offset or an RFC 3339 time.
+func ParseReplayFrom(value string) (*ReplayFrom, error) {
+	if value == replayFromEarliest {
+		return &ReplayFrom{Offset: kafka.OffsetOldest}, nil
+	}
+
+	if offset, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if offset < 0 {
+			return nil, ErrInvalidReplayFrom
+		}
+		return &ReplayFrom{Offset: offset}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, ErrInvalidReplayFrom
+	}
+	return &ReplayFrom{Time: t}, nil
+}
+
+// history replays the events of the channel recorded in Kafka from the given position
+// up to the end of its topic as of now. Each event is flagged as a replay. Topics may
+// be shared, so records of other channels are skipped.
+//
+// When Kafka is the source, records only hold the updates, so each one is replaced by
+// the versioned event it was materialized into. Records no longer in the replay stream,
+// not materialized yet or never applied are skipped, the events of the latter being
+// delivered live once materialized.
+func (u *eventUseCase) history(ctx context.Context, chID string, from *ReplayFrom) (<-chan entities.Event, error) {
+	until, err := u.kafkaEventRepo.Offsets(chID, kafka.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+
+	var start map[int32]int64
+	switch {
+	case !from.Time.IsZero():
+		start, err = u.kafkaEventRepo.Offsets(chID, from.Time.UnixMilli())
+	case from.Offset == kafka.OffsetOldest:
+		start, err = u.kafkaEventRepo.Offsets(chID, kafka.OffsetOldest)
+	default:
+		start, err = u.kafkaEventRepo.Offsets(chID, kafka.OffsetOldest)
+		for partition, oldest := range start {
+			start[partition] = min(max(from.Offset, oldest), until[partition])
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := u.kafkaEventRepo.Replay(ctx, chID, start, until)
+	if err != nil {
+		return nil, err
+	}
+
+	var materialized map[repositories.SourceOffset]entities.Event
+	if u.source == SourceKafka {
+		if materialized, err = u.materializedEvents(chID); err != nil {
+			return nil, err
+		}
+	}
+
+	history := make(chan entities.Event)
+	go func() {
+		defer close(history)
+
+		for msg := range records {
+			var event entities.Event
+			if materialized != nil {
+				var ok bool
+				source := repositories.SourceOffset{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+				if event, ok = materialized[source]; !ok {
+					continue
+				}
+			} else {
+				if err := json.Unmarshal(msg.Value, &event); err != nil {
+					log.Printf(errUnmarshalRecord, msg.Partition, msg.Offset, chID, err)
+					continue
+				}
+				if event.Id != "" && event.Id != chID {
+					continue
+				}
+			}
+
+			event.Id = chID
+			event.Replay = true
+
+			select {
+			case history <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return history, nil
+}
+
+// materializedEvents returns the events of the channel's replay stream by the Kafka
+// record they were materialized from.
+func (u *eventUseCase) materializedEvents(chID string) (map[repositories.SourceOffset]entities.Event, error) {
+	entries, err := u.redisEventRepo.Range(chID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(map[repositories.SourceOffset]entities.Event, len(entries))
+	for _, entry := range entries {
+		source, ok := repositories.StreamSource(entry)
+		if !ok {
+			continue
+		}
+
+		var event entities.Event
+		if err := json.Unmarshal([]byte(repositories.StreamPayload(entry)), &event); err != nil {
+			log.Printf(errUnmarshalEntry, entry.ID, chID, err)
+			continue
+		}
+		events[source] = event
+	}
+
+	return events, nil
+}
+
+// catchUp returns the events committed to Redis after the last one replayed from Kafka,
+// which may not have been relayed yet. A snapshot is returned instead when the replay
+// stream was trimmed past that version.
+func (u *eventUseCase) catchUp(chID string, lastVersion int64) ([]entities.Event, error) {
+	events, err := u.rangeEvents(chID, lastVersion+1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) > 0 && events[0].Version != lastVersion+1 {
+		log.Printf(errHistoryGap, lastVersion, chID)
+
+		snapshot, err := u.snapshot(chID)
+		if err != nil {
+			return nil, err
+		}
+		return []entities.Event{*snapshot}, nil
+	}
+
+	for i := range events {
+		events[i].Replay = true
+	}
+	return events, nil
+}
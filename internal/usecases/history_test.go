@@ -0,0 +1,129 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"streamline/internal/entities"
+	"streamline/pkg/kafka"
+)
+
+func TestReplayFromKafkaSource(t *testing.T) {
+	const chID = "orders.1"
+
+	redisRepo := newFakeRedisEventRepo()
+	kafkaRepo := &fakeKafkaEventRepo{topic: "events"}
+
+	// Records only hold the updates, the replay stream the versioned events
+	patches := []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}
+	documents := []string{`{"a":1}`, `{"a":1,"b":2}`, `{"a":1,"b":2,"c":3}`}
+	for i, patch := range patches {
+		source := kafkaRepo.record(chID, entities.Event{
+			Id:        chID,
+			Type:      entities.EventTypePatch,
+			Patch:     json.RawMessage(patch),
+			PatchType: entities.PatchTypeMerge,
+		})
+		redisRepo.commit(entities.Event{
+			Id:        chID,
+			Type:      entities.EventTypePatch,
+			Version:   int64(i + 1),
+			Message:   json.RawMessage(documents[i]),
+			Patch:     json.RawMessage(patch),
+			PatchType: entities.PatchTypeMerge,
+		}, source)
+	}
+
+	// A record not materialized yet is delivered live once it is
+	kafkaRepo.record(chID, entities.Event{Id: chID, Type: entities.EventTypePatch, Patch: json.RawMessage(`{"d":4}`)})
+
+	uc := NewEventUseCase(redisRepo, kafkaRepo, EventConfig{Source: SourceKafka})
+
+	// Live events queued during the replay include the last replayed one
+	redisRepo.publish(entities.Event{Id: chID, Type: entities.EventTypePatch, Version: 3, Message: json.RawMessage(documents[2])})
+	redisRepo.publish(entities.Event{Id: chID, Type: entities.EventTypePatch, Version: 4, Message: json.RawMessage(`{"a":1,"b":2,"c":3,"d":4}`)})
+
+	sub, err := uc.SubscribeAndStreamEvent(context.Background(), chID, StreamOptions{
+		From: &ReplayFrom{Offset: kafka.OffsetOldest},
+	})
+	if err != nil {
+		t.Fatalf("SubscribeAndStreamEvent: %v", err)
+	}
+	defer sub.Close()
+
+	for i, document := range documents {
+		event := receive(t, sub)
+		if event.Version != int64(i+1) || !event.Replay {
+			t.Fatalf("event %d: got version %d replay %t, expected version %d replayed", i, event.Version, event.Replay, i+1)
+		}
+		if string(event.Message) != document {
+			t.Fatalf("event %d: got document %s, expected %s", i, event.Message, document)
+		}
+	}
+
+	event := receive(t, sub)
+	if event.Version != 4 || event.Replay {
+		t.Fatalf("got version %d replay %t after the replay, expected live version 4", event.Version, event.Replay)
+	}
+}
+
+func TestReplayFromKafkaSourceSkipsTrimmedRecords(t *testing.T) {
+	const chID = "orders.1"
+
+	redisRepo := newFakeRedisEventRepo()
+	kafkaRepo := &fakeKafkaEventRepo{topic: "events"}
+
+	// The replay stream was trimmed past the first record
+	kafkaRepo.record(chID, entities.Event{Id: chID, Type: entities.EventTypePatch, Patch: json.RawMessage(`{"a":1}`)})
+	source := kafkaRepo.record(chID, entities.Event{Id: chID, Type: entities.EventTypePatch, Patch: json.RawMessage(`{"b":2}`)})
+	redisRepo.commit(entities.Event{Id: chID, Type: entities.EventTypePatch, Version: 2, Message: json.RawMessage(`{"a":1,"b":2}`)}, source)
+
+	uc := NewEventUseCase(redisRepo, kafkaRepo, EventConfig{Source: SourceKafka})
+
+	sub, err := uc.SubscribeAndStreamEvent(context.Background(), chID, StreamOptions{
+		From: &ReplayFrom{Offset: kafka.OffsetOldest},
+	})
+	if err != nil {
+		t.Fatalf("SubscribeAndStreamEvent: %v", err)
+	}
+	defer sub.Close()
+
+	if event := receive(t, sub); event.Version != 2 || string(event.Message) != `{"a":1,"b":2}` {
+		t.Fatalf("got version %d document %s, expected version 2", event.Version, event.Message)
+	}
+}
+
+func TestReplayWithoutRecordsStartsFromSnapshot(t *testing.T) {
+	const chID = "orders.1"
+
+	redisRepo := newFakeRedisEventRepo()
+	kafkaRepo := &fakeKafkaEventRepo{topic: "events"}
+
+	// The history before the replay position is only in the replay stream
+	documents := []string{`{"a":1}`, `{"a":1,"b":2}`, `{"a":1,"b":2,"c":3}`}
+	for i, document := range documents {
+		redisRepo.commit(entities.Event{Id: chID, Type: entities.EventTypePatch, Version: int64(i + 1), Message: json.RawMessage(document)}, nil)
+	}
+
+	uc := NewEventUseCase(redisRepo, kafkaRepo, EventConfig{})
+
+	sub, err := uc.SubscribeAndStreamEvent(context.Background(), chID, StreamOptions{
+		From: &ReplayFrom{Time: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("SubscribeAndStreamEvent: %v", err)
+	}
+	defer sub.Close()
+
+	event := receive(t, sub)
+	if event.Type != entities.EventTypeSnapshot || event.Version != 3 || event.Replay {
+		t.Fatalf("got %s event version %d replay %t, expected the snapshot at version 3", event.Type, event.Version, event.Replay)
+	}
+
+	redisRepo.publish(entities.Event{Id: chID, Type: entities.EventTypePatch, Version: 4, Message: json.RawMessage(`{"a":1,"b":2,"c":3,"d":4}`)})
+	if event := receive(t, sub); event.Version != 4 || event.Replay {
+		t.Fatalf("got version %d replay %t, expected live version 4", event.Version, event.Replay)
+	}
+}
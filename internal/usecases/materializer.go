@@ -0,0 +1,219 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"streamline/internal/entities"
+	"streamline/internal/repositories"
+	"streamline/pkg/kafka"
+)
+
+const (
+	errMaterializeSubscribe  = "Error subscribing to Kafka topics %v: %v"
+	errMaterializeRetry      = "Error materializing Kafka record %s/%d@%d, attempt %d, retrying in %s: %v"
+	errMaterializeDeadLetter = "Error materializing Kafka record %s/%d@%d after %d attempts, forwarding to %q: %v"
+	errMaterializeDrop       = "Error materializing Kafka record %s/%d@%d after %d attempts, skipping: %v"
+	errMaterializeDuplicate  = "Error Kafka record %s/%d@%d already materialized for channel %s, skipping"
+)
+
+// errMalformedRecord is returned for records no retry can apply.
+var errMalformedRecord = errors.New("malformed record")
+
+type (
+	// MaterializerUseCase makes Kafka the source of truth of the channels' state. It
+	// consumes the event topics, applies every record to the state of its channel in
+	// Redis and publishes the outcome to live subscribers, whether the record was
+	// produced by PublishEvent or by any other service.
+	MaterializerUseCase interface {
+		Run(ctx context.Context) error
+	}
+
+	materializerUseCase struct {
+		redisEventRepo repositories.RedisEventRepository
+		kafkaEventRepo repositories.KafkaEventRepository
+		config         MaterializerConfig
+	}
+
+	MaterializerConfig struct {
+		// Topics holding the events to materialize.
+		Topics []string
+		// Group is the consumer group of the materializer. Replicas sharing it split
+		// the partitions, yet a record read by several groups is only applied once.
+		Group string
+		// Retry spaces the attempts to apply a record failing on a transient error.
+		Retry kafka.RetryPolicy
+		// RetryTopic, when set, delays the retries through that topic rather than
+		// blocking the partition, at the cost of the record's order within its channel.
+		// Only a group shared by every replica can use it, others would consume the
+		// retries of each other.
+		RetryTopic string
+		// DeadLetterTopic receives the records that cannot be applied, along with the
+		// error. They are logged and skipped when it is empty.
+		DeadLetterTopic string
+	}
+)
+
+func NewMaterializerUseCase(
+	redisEventRepo repositories.RedisEventRepository,
+	kafkaEventRepo repositories.KafkaEventRepository,
+	config MaterializerConfig,
+) MaterializerUseCase {
+	return &materializerUseCase{
+		redisEventRepo: redisEventRepo,
+		kafkaEventRepo: kafkaEventRepo,
+		config:         config,
+	}
+}
+
+// Run materializes the topics until ctx is canceled. Records are applied in order, a
+// record is only committed once applied or forwarded to the dead-letter topic.
+func (u *materializerUseCase) Run(ctx context.Context) error {
+	topics := u.config.Topics
+	if u.config.RetryTopic != "" {
+		topics = append(topics[:len(topics):len(topics)], u.config.RetryTopic)
+	}
+
+	err := u.kafkaEventRepo.Process(ctx, topics, kafka.OffsetFromEarliest, u.config.Group, u.handle)
+	if err != nil {
+		log.Printf(errMaterializeSubscribe, topics, err)
+		return err
+	}
+
+	return nil
+}
+
+// handle applies a record, retrying on transient errors. A record which cannot be
+// applied, whether malformed, failing its precondition or out of attempts, goes to the
+// dead-letter topic. An error is only returned when ctx is done or the record could not
+// be forwarded, so that it is redelivered.
+func (u *materializerUseCase) handle(ctx context.Context, msg *kafka.Message) error {
+	// A record from the retry topic waits until it is due and keeps its attempt count
+	attempts := kafka.Attempts(msg)
+	if err := sleep(ctx, time.Until(kafka.RetryAt(msg))); err != nil {
+		return err
+	}
+
+	for {
+		err := u.materialize(msg)
+		if err == nil {
+			return nil
+		}
+		attempts++
+
+		if errors.Is(err, errMalformedRecord) || isPatchError(err) || errors.Is(err, ErrPreconditionFailed) ||
+			u.config.Retry.Exhausted(attempts) {
+			return u.deadLetter(ctx, msg, err, attempts)
+		}
+
+		delay := u.config.Retry.Delay(attempts)
+		log.Printf(errMaterializeRetry, msg.Topic, msg.Partition, msg.Offset, attempts, delay, err)
+
+		if u.config.RetryTopic != "" {
+			headers := kafka.FailureHeaders(msg, err, attempts)
+			headers[kafka.HeaderRetryAt] = fmt.Sprint(time.Now().Add(delay).UnixMilli())
+			return u.kafkaEventRepo.Forward(ctx, u.config.RetryTopic, msg, headers)
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// deadLetter forwards a record that cannot be applied to the dead-letter topic.
+func (u *materializerUseCase) deadLetter(ctx context.Context, msg *kafka.Message, cause error, attempts int) error {
+	if u.config.DeadLetterTopic == "" {
+		log.Printf(errMaterializeDrop, msg.Topic, msg.Partition, msg.Offset, attempts, cause)
+		return nil
+	}
+
+	log.Printf(errMaterializeDeadLetter, msg.Topic, msg.Partition, msg.Offset, attempts, u.config.DeadLetterTopic, cause)
+	headers := kafka.FailureHeaders(msg, cause, attempts)
+	return u.kafkaEventRepo.Forward(ctx, u.config.DeadLetterTopic, msg, headers)
+}
+
+// materialize applies a record to the state of its channel. A record holds an event
+// of which the type and either the patch or the new document are read. Its channel is
+// the record key, or the event ID, or the topic it was first produced to when both are
+// missing. A record produced with a precondition, in its entities.HeaderIfMatch header,
+// is only applied to a state matching it. A record already applied, including through
+// the retry topic, is skipped.
+func (u *materializerUseCase) materialize(msg *kafka.Message) error {
+	var record entities.Event
+	if err := json.Unmarshal(msg.Value, &record); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedRecord, err)
+	}
+
+	precondition, err := preconditionFrom(msg.Headers[entities.HeaderIfMatch])
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformedRecord, err)
+	}
+
+	chID := string(msg.Key)
+	if chID == "" {
+		chID = record.Id
+	}
+	if chID == "" {
+		chID = msg.Headers[kafka.HeaderOriginalTopic]
+	}
+	if chID == "" {
+		chID = msg.Topic
+	}
+
+	var next func(current *entities.Event) (*entities.Event, error)
+	switch {
+	case record.Type == entities.EventTypeDeleted:
+		next = deleteEvent(chID)
+	case len(record.Patch) > 0:
+		patchType := record.PatchType
+		if patchType == "" {
+			patchType = entities.PatchTypeReplace
+		}
+		next = patchEvent(chID, patchType, record.Patch)
+	default:
+		next = patchEvent(chID, entities.PatchTypeReplace, record.Message)
+	}
+
+	// Services producing directly into Kafka may only describe the event in the headers
+	publisher, traceParent := record.Publisher, record.TraceParent
+	if publisher == "" {
+		publisher = msg.Headers[entities.HeaderPublisher]
+	}
+	if traceParent == "" {
+		traceParent = msg.Headers[entities.HeaderTraceParent]
+	}
+	next = stamped(publisher, traceParent, next)
+
+	// A retried record is the record it was forwarded from, wherever it is read from
+	source := &repositories.SourceOffset{}
+	source.Topic, source.Partition, source.Offset = kafka.Origin(msg)
+
+	_, err = updateState(u.redisEventRepo, chID, precondition, source, next)
+	if errors.Is(err, repositories.ErrAlreadyApplied) {
+		log.Printf(errMaterializeDuplicate, msg.Topic, msg.Partition, msg.Offset, chID)
+		return nil
+	}
+	return err
+}
+
+// sleep waits for the delay, unless ctx is done first.
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
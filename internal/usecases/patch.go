@@ -0,0 +1,58 @@
+package usecases
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"streamline/internal/entities"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// isPatchError reports whether err is caused by the patch sent by the client.
+func isPatchError(err error) bool {
+	return errors.Is(err, ErrInvalidPatch) || errors.Is(err, ErrPatchFailed) || errors.Is(err, ErrPatchType)
+}
+
+// emptyDocument is patched when a channel has no state yet.
+var emptyDocument = json.RawMessage("{}")
+
+// applyPatch returns the document resulting from applying the patch to the current one.
+func applyPatch(document json.RawMessage, patchType string, patch json.RawMessage) (json.RawMessage, error) {
+	if !json.Valid(patch) {
+		return nil, ErrInvalidPatch
+	}
+
+	if len(document) == 0 || bytes.Equal(document, []byte("null")) {
+		document = emptyDocument
+	}
+
+	switch patchType {
+	case entities.PatchTypeReplace:
+		return patch, nil
+
+	case entities.PatchTypeMerge:
+		result, err := jsonpatch.MergePatch(document, patch)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPatchFailed, err)
+		}
+		return result, nil
+
+	case entities.PatchTypeJSONPatch:
+		operations, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+
+		result, err := operations.Apply(document)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPatchFailed, err)
+		}
+		return result, nil
+
+	default:
+		return nil, ErrPatchType
+	}
+}
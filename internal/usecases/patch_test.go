@@ -0,0 +1,129 @@
+package usecases
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"streamline/internal/entities"
+)
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		document  string
+		patchType string
+		patch     string
+		expected  string
+		err       error
+	}{
+		{
+			name:      "replace",
+			document:  `{"a":1}`,
+			patchType: entities.PatchTypeReplace,
+			patch:     `{"b":2}`,
+			expected:  `{"b":2}`,
+		},
+		{
+			name:      "merge patch",
+			document:  `{"a":1,"b":{"c":2,"d":3}}`,
+			patchType: entities.PatchTypeMerge,
+			patch:     `{"a":null,"b":{"c":4},"e":5}`,
+			expected:  `{"b":{"c":4,"d":3},"e":5}`,
+		},
+		{
+			name:      "merge patch on a channel without state",
+			patchType: entities.PatchTypeMerge,
+			patch:     `{"a":1}`,
+			expected:  `{"a":1}`,
+		},
+		{
+			name:      "merge patch on a null document",
+			document:  `null`,
+			patchType: entities.PatchTypeMerge,
+			patch:     `{"a":1}`,
+			expected:  `{"a":1}`,
+		},
+		{
+			name:      "JSON Patch",
+			document:  `{"a":1,"items":["x"]}`,
+			patchType: entities.PatchTypeJSONPatch,
+			patch:     `[{"op":"test","path":"/a","value":1},{"op":"replace","path":"/a","value":2},{"op":"add","path":"/items/-","value":"y"}]`,
+			expected:  `{"a":2,"items":["x","y"]}`,
+		},
+		{
+			name:      "JSON Patch failing test",
+			document:  `{"a":1}`,
+			patchType: entities.PatchTypeJSONPatch,
+			patch:     `[{"op":"test","path":"/a","value":2},{"op":"replace","path":"/a","value":3}]`,
+			err:       ErrPatchFailed,
+		},
+		{
+			name:      "JSON Patch removing a missing path",
+			document:  `{"a":1}`,
+			patchType: entities.PatchTypeJSONPatch,
+			patch:     `[{"op":"remove","path":"/b"}]`,
+			err:       ErrPatchFailed,
+		},
+		{
+			name:      "JSON Patch not an array of operations",
+			document:  `{"a":1}`,
+			patchType: entities.PatchTypeJSONPatch,
+			patch:     `{"op":"remove","path":"/a"}`,
+			err:       ErrInvalidPatch,
+		},
+		{
+			name:      "malformed patch",
+			document:  `{"a":1}`,
+			patchType: entities.PatchTypeMerge,
+			patch:     `{"a":`,
+			err:       ErrInvalidPatch,
+		},
+		{
+			name:      "unsupported patch type",
+			document:  `{"a":1}`,
+			patchType: "application/xml",
+			patch:     `{"a":2}`,
+			err:       ErrPatchType,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var document json.RawMessage
+			if test.document != "" {
+				document = json.RawMessage(test.document)
+			}
+
+			result, err := applyPatch(document, test.patchType, json.RawMessage(test.patch))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, expected %v", err, test.err)
+			}
+			if test.err != nil {
+				if !isPatchError(err) {
+					t.Fatalf("error %v not reported as caused by the patch", err)
+				}
+				return
+			}
+
+			if !jsonEqual(t, result, json.RawMessage(test.expected)) {
+				t.Fatalf("got document %s, expected %s", result, test.expected)
+			}
+		})
+	}
+}
+
+// jsonEqual reports whether both documents hold the same JSON value.
+func jsonEqual(t *testing.T, a, b json.RawMessage) bool {
+	t.Helper()
+
+	var valueA, valueB interface{}
+	if err := json.Unmarshal(a, &valueA); err != nil {
+		t.Fatalf("unmarshaling %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &valueB); err != nil {
+		t.Fatalf("unmarshaling %s: %v", b, err)
+	}
+	return reflect.DeepEqual(valueA, valueB)
+}
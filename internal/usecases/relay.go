@@ -0,0 +1,231 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"streamline/internal/entities"
+	"streamline/internal/repositories"
+	"streamline/pkg/redis"
+)
+
+const (
+	errRelayInit      = "Error creating the outbox consumer group: %v"
+	errRelayRead      = "Error reading the outbox: %v"
+	errRelayDeliver   = "Error relaying event %d of channel %s to Kafka, retrying in %s: %v"
+	errRelayDone      = "Error completing outbox entry %s of channel %s: %v"
+	errRelayDelivered = "Error checking whether event %d of channel %s was relayed: %v"
+	errRelayHeld      = "Error holding event %d of channel %s until its earlier events are relayed"
+)
+
+type (
+	// RelayUseCase delivers to Kafka the events committed to Redis, which records them
+	// in its outbox in the same transaction as the channel's state. An event is kept
+	// in the outbox until Kafka acknowledges it, so both transports always agree.
+	//
+	// Delivery is at least once: an event is produced again when the relay dies after
+	// Kafka acknowledged it but before it is marked as delivered, so consumers dedupe
+	// by channel and version, which every record carries in its headers.
+	RelayUseCase interface {
+		Run(ctx context.Context) error
+	}
+
+	relayUseCase struct {
+		outboxRepo     repositories.OutboxRepository
+		kafkaEventRepo repositories.KafkaEventRepository
+		config         RelayConfig
+
+		// blocked holds, by channel, the IDs of the entries left pending after one of
+		// them failed, in outbox order. Only Run accesses it.
+		blocked map[string][]string
+	}
+
+	RelayConfig struct {
+		// Consumer names this relay in the outbox consumer group, unique per replica.
+		Consumer string
+		// BatchSize is the maximum number of events read from the outbox at once.
+		BatchSize int64
+		// Block is how long the outbox is waited on when it is empty.
+		Block time.Duration
+		// RetryInterval is how long an event whose delivery failed, or whose relay
+		// died, stays pending before it is attempted again.
+		RetryInterval time.Duration
+	}
+)
+
+func NewRelayUseCase(
+	outboxRepo repositories.OutboxRepository,
+	kafkaEventRepo repositories.KafkaEventRepository,
+	config RelayConfig,
+) RelayUseCase {
+	return &relayUseCase{
+		outboxRepo:     outboxRepo,
+		kafkaEventRepo: kafkaEventRepo,
+		config:         config,
+		blocked:        make(map[string][]string),
+	}
+}
+
+// Run relays the outbox until ctx is canceled. Pending events are retried before new
+// ones are read. Once an event of a channel fails, the later events of the channel are
+// held, pending, until it is delivered, so a relay delivers the events of a channel in
+// order. Replicas share the outbox, a channel's events taken over from a dead replica
+// may therefore be delivered after newer ones.
+func (u *relayUseCase) Run(ctx context.Context) error {
+	if err := u.outboxRepo.Init(); err != nil {
+		log.Printf(errRelayInit, err)
+		return err
+	}
+
+	for ctx.Err() == nil {
+		entries, err := u.outboxRepo.Claim(u.config.Consumer, u.config.RetryInterval, u.config.BatchSize)
+		if err == nil && len(entries) == 0 {
+			entries, err = u.outboxRepo.Read(ctx, u.config.Consumer, u.config.BatchSize, u.config.Block)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf(errRelayRead, err)
+			u.wait(ctx)
+			continue
+		}
+
+		u.relay(ctx, entries)
+	}
+
+	return nil
+}
+
+// relay delivers a batch of entries, the channels at once so an async producer batches
+// them, and the entries of each channel one after the other, each once the previous one
+// is acknowledged. The entries of a blocked channel are held unless they are the next
+// ones it waits for. Once an event of a channel fails, the following ones of that channel
+// are left pending without being produced.
+func (u *relayUseCase) relay(ctx context.Context, entries []*repositories.OutboxEntry) {
+	results := make([]error, len(entries))
+	held := make([]bool, len(entries))
+	next := make(map[string]int)
+
+	// Indexes of the entries to deliver, by channel in outbox order
+	var channels []string
+	queues := make(map[string][]int)
+	for i, entry := range entries {
+		if ids := u.blocked[entry.Channel]; len(ids) > 0 {
+			if k := next[entry.Channel]; k >= len(ids) || ids[k] != entry.ID {
+				held[i] = true
+				continue
+			}
+			next[entry.Channel]++
+		}
+
+		if _, ok := queues[entry.Channel]; !ok {
+			channels = append(channels, entry.Channel)
+		}
+		queues[entry.Channel] = append(queues[entry.Channel], i)
+	}
+
+	var wg sync.WaitGroup
+	for _, chID := range channels {
+		wg.Add(1)
+		go func(queue []int) {
+			defer wg.Done()
+
+			for _, i := range queue {
+				if results[i] = u.deliverAndWait(ctx, entries[i]); results[i] != nil {
+					return
+				}
+			}
+		}(queues[chID])
+	}
+	wg.Wait()
+
+	failed := make(map[string]bool)
+	for i, entry := range entries {
+		if held[i] || failed[entry.Channel] {
+			u.block(entry)
+			if held[i] && !failed[entry.Channel] {
+				log.Printf(errRelayHeld, entry.Version, entry.Channel)
+			}
+			continue
+		}
+
+		if err := results[i]; err != nil {
+			log.Printf(errRelayDeliver, entry.Version, entry.Channel, u.config.RetryInterval, err)
+			failed[entry.Channel] = true
+			u.block(entry)
+			continue
+		}
+
+		if err := u.outboxRepo.Done(entry); err != nil {
+			log.Printf(errRelayDone, entry.ID, entry.Channel, err)
+		}
+		u.unblock(entry)
+	}
+}
+
+// block leaves the entry pending, holding back the later entries of its channel.
+func (u *relayUseCase) block(entry *repositories.OutboxEntry) {
+	ids := u.blocked[entry.Channel]
+	i, found := slices.BinarySearchFunc(ids, entry.ID, redis.CompareStreamIDs)
+	if !found {
+		u.blocked[entry.Channel] = slices.Insert(ids, i, entry.ID)
+	}
+}
+
+// unblock forgets a delivered entry, releasing its channel once none is left pending.
+func (u *relayUseCase) unblock(entry *repositories.OutboxEntry) {
+	ids := u.blocked[entry.Channel]
+	if i, found := slices.BinarySearchFunc(ids, entry.ID, redis.CompareStreamIDs); found {
+		ids = slices.Delete(ids, i, i+1)
+	}
+	if len(ids) == 0 {
+		delete(u.blocked, entry.Channel)
+		return
+	}
+	u.blocked[entry.Channel] = ids
+}
+
+// deliverAndWait delivers the entry and waits until Kafka acknowledges it.
+func (u *relayUseCase) deliverAndWait(ctx context.Context, entry *repositories.OutboxEntry) error {
+	done := make(chan error, 1)
+	if err := u.deliver(ctx, entry, func(err error) {
+		done <- err
+	}); err != nil {
+		return err
+	}
+	return <-done
+}
+
+// deliver produces the entry's event to Kafka unless an earlier attempt already did,
+// and reports the outcome to onDelivery.
+func (u *relayUseCase) deliver(ctx context.Context, entry *repositories.OutboxEntry, onDelivery func(err error)) error {
+	delivered, err := u.outboxRepo.Delivered(entry)
+	if err != nil {
+		log.Printf(errRelayDelivered, entry.Version, entry.Channel, err)
+	}
+	if delivered {
+		onDelivery(nil)
+		return nil
+	}
+
+	// The headers describe the event, even one that cannot be decoded
+	event := entities.Event{Id: entry.Channel, Version: entry.Version}
+	_ = json.Unmarshal(entry.Payload, &event)
+
+	return u.kafkaEventRepo.PublishAsync(ctx, entry.Channel, event.Headers(), json.RawMessage(entry.Payload), onDelivery)
+}
+
+func (u *relayUseCase) wait(ctx context.Context) {
+	timer := time.NewTimer(u.config.RetryInterval)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
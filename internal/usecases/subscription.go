@@ -0,0 +1,130 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"streamline/internal/entities"
+)
+
+// Reasons a subscription ends for, sent to clients so they know whether and how
+// to reconnect.
+const (
+	// ReasonCanceled: the client went away or the subscription was closed.
+	ReasonCanceled = "canceled"
+	// ReasonSlowConsumer: the subscriber fell too far behind, it may resume from
+	// the version of its last event.
+	ReasonSlowConsumer = "slow-consumer"
+	// ReasonUpstreamClosed: the channel's upstream ended, e.g. Redis went away.
+	ReasonUpstreamClosed = "upstream-closed"
+	// ReasonReplayFailed: the events missed by the subscriber could not be read.
+	ReasonReplayFailed = "replay-failed"
+	// ReasonStateUnavailable: the state of a channel could not be loaded.
+	ReasonStateUnavailable = "state-unavailable"
+)
+
+var (
+	ErrSubscriptionClosed = errors.New("subscription closed")
+	ErrUpstreamClosed     = errors.New("upstream closed")
+)
+
+type (
+	// Subscription is a stream of events being delivered to a subscriber. Events is
+	// closed when the stream ends, after which Err tells why.
+	Subscription interface {
+		Events() <-chan entities.Event
+		// Err returns nil while the stream is running, then a *StreamError.
+		Err() error
+		Done() <-chan struct{}
+		// Close ends the stream and waits for it to release its resources.
+		Close()
+	}
+
+	// StreamError is why a subscription ended, along with a reason code for clients.
+	StreamError struct {
+		Reason string
+		Err    error
+	}
+
+	subscription struct {
+		events  chan entities.Event
+		done    chan struct{}
+		cancel  context.CancelCauseFunc
+		release func()
+		once    sync.Once
+		err     error
+	}
+)
+
+// newSubscription returns a subscription along with the context its producer runs
+// under, which is canceled when the subscription is closed. Release frees the upstream
+// subscriptions of the stream once it ends, before Close returns.
+func newSubscription(ctx context.Context, release func()) (*subscription, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	return &subscription{
+		events:  make(chan entities.Event),
+		done:    make(chan struct{}),
+		cancel:  cancel,
+		release: release,
+	}, ctx
+}
+
+func (s *subscription) Events() <-chan entities.Event {
+	return s.events
+}
+
+func (s *subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+func (s *subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *subscription) Close() {
+	s.cancel(ErrSubscriptionClosed)
+	<-s.done
+}
+
+// send delivers an event, giving up as soon as the subscription is canceled so a
+// stream that stopped reading never holds on to the upstream subscriptions.
+func (s *subscription) send(ctx context.Context, event entities.Event) bool {
+	select {
+	case s.events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// end records why the stream ended, releases its resources and closes it. Only the
+// producer calls it, once.
+func (s *subscription) end(reason string, err error) {
+	s.once.Do(func() {
+		s.err = &StreamError{Reason: reason, Err: err}
+		s.cancel(err)
+		s.release()
+		close(s.done)
+		close(s.events)
+	})
+}
+
+// canceled ends the stream because its context was canceled.
+func (s *subscription) canceled(ctx context.Context) {
+	s.end(ReasonCanceled, context.Cause(ctx))
+}
+
+func (e *StreamError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
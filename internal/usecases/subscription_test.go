@@ -0,0 +1,128 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"streamline/internal/entities"
+	"streamline/pkg/hub"
+)
+
+func TestSubscriptionEndsCanceled(t *testing.T) {
+	const chID = "orders.1"
+
+	redisRepo := newFakeRedisEventRepo()
+	uc := NewEventUseCase(redisRepo, nil, EventConfig{})
+
+	sub, err := uc.SubscribeAndStreamEvent(context.Background(), chID, StreamOptions{})
+	if err != nil {
+		t.Fatalf("SubscribeAndStreamEvent: %v", err)
+	}
+	receive(t, sub)
+
+	sub.Close()
+
+	// Close only returns once the hub subscriber is released
+	if subscribers := uc.Stats()[chID].Subscribers; subscribers != 0 {
+		t.Fatalf("got %d subscribers after Close, expected none", subscribers)
+	}
+	if reason := endReason(t, sub); reason != ReasonCanceled {
+		t.Fatalf("got reason %q, expected %q", reason, ReasonCanceled)
+	}
+}
+
+func TestSubscriptionEndsSlowConsumer(t *testing.T) {
+	const chID = "orders.1"
+
+	redisRepo := newFakeRedisEventRepo()
+	uc := NewEventUseCase(redisRepo, nil, EventConfig{SubscriberBufferSize: 1, Overflow: hub.PolicyDisconnect})
+
+	sub, err := uc.SubscribeAndStreamEvent(context.Background(), chID, StreamOptions{})
+	if err != nil {
+		t.Fatalf("SubscribeAndStreamEvent: %v", err)
+	}
+	defer sub.Close()
+
+	// The stream waits for the snapshot to be read while the live events pile up
+	for version := int64(1); version <= 3; version++ {
+		redisRepo.publish(entities.Event{Id: chID, Type: entities.EventTypePatch, Version: version, Message: json.RawMessage(`{}`)})
+	}
+	waitFor(t, func() bool { return uc.Stats()[chID].Subscribers == 0 })
+
+	var last entities.Event
+	for event := range sub.Events() {
+		last = event
+	}
+
+	var streamErr *StreamError
+	if !errors.As(sub.Err(), &streamErr) || streamErr.Reason != ReasonSlowConsumer {
+		t.Fatalf("subscription ended with %v, expected reason %q", sub.Err(), ReasonSlowConsumer)
+	}
+	if last.Type != entities.EventTypeSlowConsumer {
+		t.Fatalf("got last event of type %q, expected %q", last.Type, entities.EventTypeSlowConsumer)
+	}
+}
+
+func TestSubscriptionEndsUpstreamClosed(t *testing.T) {
+	const chID = "orders.1"
+
+	redisRepo := newFakeRedisEventRepo()
+	uc := NewEventUseCase(redisRepo, nil, EventConfig{})
+
+	sub, err := uc.SubscribeAndStreamEvent(context.Background(), chID, StreamOptions{})
+	if err != nil {
+		t.Fatalf("SubscribeAndStreamEvent: %v", err)
+	}
+	defer sub.Close()
+	receive(t, sub)
+
+	close(redisRepo.upstream(chID))
+
+	if reason := endReason(t, sub); reason != ReasonUpstreamClosed {
+		t.Fatalf("got reason %q, expected %q", reason, ReasonUpstreamClosed)
+	}
+}
+
+func TestSubscriptionEndsReplayFailed(t *testing.T) {
+	const chID = "orders.1"
+
+	redisRepo := newFakeRedisEventRepo()
+	redisRepo.commit(entities.Event{Id: chID, Type: entities.EventTypePatch, Version: 1, Message: json.RawMessage(`{"a":1}`)}, nil)
+	uc := NewEventUseCase(redisRepo, nil, EventConfig{})
+
+	sub, err := uc.SubscribeAndStreamEvent(context.Background(), chID, StreamOptions{Mode: entities.DeliveryPatch})
+	if err != nil {
+		t.Fatalf("SubscribeAndStreamEvent: %v", err)
+	}
+	defer sub.Close()
+
+	if event := receive(t, sub); event.Version != 1 {
+		t.Fatalf("got snapshot at version %d, expected 1", event.Version)
+	}
+
+	// Version 2 was dropped, and the replay stream cannot tell what it was
+	redisRepo.mu.Lock()
+	redisRepo.rangeErr = errors.New("replay stream unavailable")
+	redisRepo.mu.Unlock()
+	redisRepo.publish(entities.Event{Id: chID, Type: entities.EventTypePatch, Version: 3, Patch: json.RawMessage(`{"c":3}`)})
+
+	if reason := endReason(t, sub); reason != ReasonReplayFailed {
+		t.Fatalf("got reason %q, expected %q", reason, ReasonReplayFailed)
+	}
+}
+
+// waitFor polls the condition until it holds, failing the test when it does not in time.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(receiveTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+const (
+	MethodAPIKey = "api-key"
+)
+
+type apiKeyAuthenticator struct {
+	keys map[string]string
+}
+
+// NewAPIKeyAuthenticator authenticates the static API keys sent in the X-API-Key
+// header. keys maps each API key to the subject it identifies.
+func NewAPIKeyAuthenticator(keys map[string]string) Authenticator {
+	return &apiKeyAuthenticator{keys: keys}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	// Compare against every key so the time taken does not reveal a match
+	var subject string
+	for candidate, owner := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			subject = owner
+		}
+	}
+
+	if subject == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{Subject: subject, Method: MethodAPIKey}, nil
+}
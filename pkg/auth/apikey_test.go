@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	authenticator := NewAPIKeyAuthenticator(map[string]string{
+		"key-a": "service-a",
+		"key-b": "service-b",
+	})
+
+	tests := []struct {
+		name     string
+		key      string
+		subject  string
+		expected error
+	}{
+		{name: "known key", key: "key-b", subject: "service-b"},
+		{name: "unknown key", key: "key-c", expected: ErrInvalidCredentials},
+		{name: "prefix of a known key", key: "key", expected: ErrInvalidCredentials},
+		{name: "no key", expected: ErrNoCredentials},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if test.key != "" {
+				r.Header.Set(APIKeyHeader, test.key)
+			}
+
+			identity, err := authenticator.Authenticate(r)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("got error %v, expected %v", err, test.expected)
+			}
+			if test.expected != nil {
+				return
+			}
+			if identity.Subject != test.subject || identity.Method != MethodAPIKey {
+				t.Fatalf("got identity %+v, expected subject %q by %s", identity, test.subject, MethodAPIKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Header and query parameter names carrying credentials.
+const (
+	AuthorizationHeader = "Authorization"
+	APIKeyHeader        = "X-API-Key"
+	TokenQueryParam     = "access_token"
+	BearerPrefix        = "Bearer "
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrExpiredCredentials = errors.New("expired credentials")
+)
+
+type (
+	// Authenticator identifies the caller of a request. It returns ErrNoCredentials
+	// when the request carries no credentials it understands, so several
+	// authenticators can be chained.
+	Authenticator interface {
+		Authenticate(r *http.Request) (*Identity, error)
+	}
+
+	// Identity is the authenticated caller of a request.
+	Identity struct {
+		Subject string
+		Method  string
+	}
+
+	chain []Authenticator
+
+	identityKey   struct{}
+	queryTokenKey struct{}
+)
+
+// Chain returns an authenticator trying each of the given ones in order. The first
+// one finding credentials decides, invalid credentials are never retried elsewhere.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return chain(authenticators)
+}
+
+func (c chain) Authenticate(r *http.Request) (*Identity, error) {
+	for _, authenticator := range c {
+		identity, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return identity, err
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// WithIdentity returns a copy of ctx carrying the identity.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity carried by ctx, or nil.
+func IdentityFrom(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// WithQueryToken returns a copy of the request of which the token may be read from the
+// query string, for routes opened by browser EventSource and WebSocket, which cannot set
+// headers. Other routes should not accept it, URLs end up in logs and browser history.
+func WithQueryToken(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), queryTokenKey{}, true))
+}
+
+// bearerToken returns the token of the Authorization header. On GET requests allowed by
+// WithQueryToken, a token in the query string is accepted too.
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get(AuthorizationHeader); strings.HasPrefix(header, BearerPrefix) {
+		return strings.TrimPrefix(header, BearerPrefix)
+	}
+
+	if allowed, _ := r.Context().Value(queryTokenKey{}).(bool); allowed && r.Method == http.MethodGet {
+		return r.URL.Query().Get(TokenQueryParam)
+	}
+
+	return ""
+}
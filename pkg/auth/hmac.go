@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	MethodHMAC = "hmac"
+)
+
+type (
+	// HMACSigner issues and verifies compact signed tokens of the form
+	// base64url(claims) "." base64url(HMAC-SHA256(claims)).
+	HMACSigner interface {
+		Authenticator
+		Sign(subject string, ttl time.Duration) (string, error)
+	}
+
+	hmacSigner struct {
+		secret []byte
+	}
+
+	hmacClaims struct {
+		Subject   string `json:"sub"`
+		ExpiresAt int64  `json:"exp"`
+	}
+)
+
+// NewHMACAuthenticator authenticates tokens signed with the shared secret, sent as a
+// bearer token or, for streams, in the access_token query parameter.
+func NewHMACAuthenticator(secret string) HMACSigner {
+	return &hmacSigner{secret: []byte(secret)}
+}
+
+// Sign issues a token identifying the subject until the TTL elapses.
+func (a *hmacSigner) Sign(subject string, ttl time.Duration) (string, error) {
+	payload, err := json.Marshal(hmacClaims{
+		Subject:   subject,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(a.mac(encoded)), nil
+}
+
+func (a *hmacSigner) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+
+	// Tokens with three segments are JWTs, left to the JWT authenticator
+	if token == "" || strings.Count(token, ".") != 1 {
+		return nil, ErrNoCredentials
+	}
+
+	encoded, signature, _ := strings.Cut(token, ".")
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, a.mac(encoded)) {
+		return nil, ErrInvalidCredentials
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	var claims hmacClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredCredentials
+	}
+
+	return &Identity{Subject: claims.Subject, Method: MethodHMAC}, nil
+}
+
+func (a *hmacSigner) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, a.secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
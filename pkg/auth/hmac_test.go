@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACAuthenticator(t *testing.T) {
+	signer := NewHMACAuthenticator("secret")
+
+	valid, err := signer.Sign("user-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	expired, err := signer.Sign("user-1", -time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	forged, err := NewHMACAuthenticator("other secret").Sign("user-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	// The claims of another token under the signature of the valid one
+	other, err := signer.Sign("user-2", time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	claims, _, _ := strings.Cut(other, ".")
+	_, signature, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{name: "valid token", token: valid},
+		{name: "expired token", token: expired, expected: ErrExpiredCredentials},
+		{name: "signed with another secret", token: forged, expected: ErrInvalidCredentials},
+		{name: "tampered claims", token: claims + "." + signature, expected: ErrInvalidCredentials},
+		{name: "malformed signature", token: claims + ".!", expected: ErrInvalidCredentials},
+		{name: "JWT left to the JWT authenticator", token: "a.b.c", expected: ErrNoCredentials},
+		{name: "no token", expected: ErrNoCredentials},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if test.token != "" {
+				r.Header.Set(AuthorizationHeader, BearerPrefix+test.token)
+			}
+
+			identity, err := signer.Authenticate(r)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("got error %v, expected %v", err, test.expected)
+			}
+			if test.expected != nil {
+				return
+			}
+			if identity.Subject != "user-1" || identity.Method != MethodHMAC {
+				t.Fatalf("got identity %+v, expected subject user-1 by %s", identity, MethodHMAC)
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticatorQueryToken(t *testing.T) {
+	signer := NewHMACAuthenticator("secret")
+	token, err := signer.Sign("user-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	// The query string is only read on the routes allowing it
+	r := httptest.NewRequest("GET", "/?"+TokenQueryParam+"="+token, nil)
+	if _, err := signer.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("got error %v without WithQueryToken, expected %v", err, ErrNoCredentials)
+	}
+	if _, err := signer.Authenticate(WithQueryToken(r)); err != nil {
+		t.Fatalf("got error %v with WithQueryToken, expected none", err)
+	}
+}
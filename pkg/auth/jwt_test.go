@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testKid = "key-1"
+
+// newTestJWTAuthenticator writes a JWKS holding the public half of the returned key
+// and builds the authenticator reading it.
+func newTestJWTAuthenticator(t *testing.T, config JWTConfig) (Authenticator, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	data, err := json.Marshal(jwks{Keys: []jwk{{
+		Kid: testKid,
+		Kty: "EC",
+		Use: "sig",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}})
+	if err != nil {
+		t.Fatalf("marshaling JWKS: %v", err)
+	}
+
+	config.JWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(config.JWKSFile, data, 0o600); err != nil {
+		t.Fatalf("writing JWKS: %v", err)
+	}
+
+	authenticator, err := NewJWTAuthenticator(config)
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator: %v", err)
+	}
+
+	return authenticator, key
+}
+
+// signJWT issues an ES256 token with the claims, naming the key kid when set.
+func signJWT(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwt.RegisteredClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	authenticator, key := newTestJWTAuthenticator(t, JWTConfig{Issuer: "streamline", Audience: "clients"})
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	valid := jwt.RegisteredClaims{
+		Subject:   "user-1",
+		Issuer:    "streamline",
+		Audience:  jwt.ClaimStrings{"clients"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+	with := func(change func(claims *jwt.RegisteredClaims)) jwt.RegisteredClaims {
+		claims := valid
+		change(&claims)
+		return claims
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{
+			name:  "valid token",
+			token: signJWT(t, key, testKid, valid),
+		},
+		{
+			name:  "single key without kid",
+			token: signJWT(t, key, "", valid),
+		},
+		{
+			name: "expired token",
+			token: signJWT(t, key, testKid, with(func(claims *jwt.RegisteredClaims) {
+				claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			})),
+			expected: ErrExpiredCredentials,
+		},
+		{
+			name: "no expiry",
+			token: signJWT(t, key, testKid, with(func(claims *jwt.RegisteredClaims) {
+				claims.ExpiresAt = nil
+			})),
+			expected: ErrInvalidCredentials,
+		},
+		{
+			name:     "signed with another key",
+			token:    signJWT(t, other, testKid, valid),
+			expected: ErrInvalidCredentials,
+		},
+		{
+			name:     "unknown kid",
+			token:    signJWT(t, key, "key-2", valid),
+			expected: ErrInvalidCredentials,
+		},
+		{
+			name: "wrong issuer",
+			token: signJWT(t, key, testKid, with(func(claims *jwt.RegisteredClaims) {
+				claims.Issuer = "elsewhere"
+			})),
+			expected: ErrInvalidCredentials,
+		},
+		{
+			name: "wrong audience",
+			token: signJWT(t, key, testKid, with(func(claims *jwt.RegisteredClaims) {
+				claims.Audience = jwt.ClaimStrings{"others"}
+			})),
+			expected: ErrInvalidCredentials,
+		},
+		{
+			name: "no subject",
+			token: signJWT(t, key, testKid, with(func(claims *jwt.RegisteredClaims) {
+				claims.Subject = ""
+			})),
+			expected: ErrInvalidCredentials,
+		},
+		{
+			name:     "HMAC token left to the HMAC authenticator",
+			token:    "a.b",
+			expected: ErrNoCredentials,
+		},
+		{
+			name:     "no token",
+			expected: ErrNoCredentials,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if test.token != "" {
+				r.Header.Set(AuthorizationHeader, BearerPrefix+test.token)
+			}
+
+			identity, err := authenticator.Authenticate(r)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("got error %v, expected %v", err, test.expected)
+			}
+			if test.expected != nil {
+				return
+			}
+			if identity.Subject != "user-1" || identity.Method != MethodJWT {
+				t.Fatalf("got identity %+v, expected subject user-1 by %s", identity, MethodJWT)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorRejectsHMACSignedToken(t *testing.T) {
+	authenticator, _ := newTestJWTAuthenticator(t, JWTConfig{})
+
+	// Only asymmetric algorithms are accepted, whatever the token claims
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(AuthorizationHeader, BearerPrefix+token)
+	if _, err := authenticator.Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidCredentials)
+	}
+}
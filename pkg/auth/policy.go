@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"path"
+)
+
+// Permission is an action a caller performs on a channel.
+type Permission int
+
+const (
+	PermissionRead Permission = iota
+	PermissionWrite
+)
+
+// AnySubject makes a rule apply to every authenticated caller.
+const AnySubject = "*"
+
+type (
+	// Authorizer decides whether a caller may perform an action on a channel.
+	Authorizer interface {
+		Allowed(identity *Identity, chID string, permission Permission) bool
+	}
+
+	// Rule grants a subject read and/or write access to the channels matching
+	// a glob-style pattern, e.g. "orders.*".
+	Rule struct {
+		Subject string
+		Pattern string
+		Read    bool
+		Write   bool
+	}
+
+	policy struct {
+		rules []Rule
+	}
+
+	allowAll struct{}
+)
+
+// NewPolicy returns an authorizer granting exactly what the rules allow.
+// Anything not matched by a rule is denied.
+func NewPolicy(rules []Rule) Authorizer {
+	return &policy{rules: rules}
+}
+
+// AllowAll returns an authorizer granting everything, used when authentication is disabled.
+func AllowAll() Authorizer {
+	return allowAll{}
+}
+
+func (p *policy) Allowed(identity *Identity, chID string, permission Permission) bool {
+	if identity == nil {
+		return false
+	}
+
+	for _, rule := range p.rules {
+		if rule.Subject != identity.Subject && rule.Subject != AnySubject {
+			continue
+		}
+		if matched, err := path.Match(rule.Pattern, chID); err != nil || !matched {
+			continue
+		}
+
+		switch permission {
+		case PermissionRead:
+			if rule.Read {
+				return true
+			}
+		case PermissionWrite:
+			if rule.Write {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (allowAll) Allowed(*Identity, string, Permission) bool {
+	return true
+}
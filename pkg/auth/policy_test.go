@@ -0,0 +1,46 @@
+package auth
+
+import "testing"
+
+func TestPolicy(t *testing.T) {
+	policy := NewPolicy([]Rule{
+		{Subject: "reader", Pattern: "orders.*", Read: true},
+		{Subject: "writer", Pattern: "orders.*", Read: true, Write: true},
+		{Subject: AnySubject, Pattern: "public.*", Read: true},
+		{Subject: "reader", Pattern: "[", Read: true},
+	})
+
+	tests := []struct {
+		name       string
+		subject    string
+		chID       string
+		permission Permission
+		expected   bool
+	}{
+		{name: "read granted", subject: "reader", chID: "orders.1", permission: PermissionRead, expected: true},
+		{name: "write not granted", subject: "reader", chID: "orders.1", permission: PermissionWrite},
+		{name: "write granted", subject: "writer", chID: "orders.1", permission: PermissionWrite, expected: true},
+		{name: "pattern not matched", subject: "writer", chID: "invoices.1", permission: PermissionRead},
+		{name: "pattern matches whole IDs", subject: "reader", chID: "orders", permission: PermissionRead},
+		{name: "any subject", subject: "someone", chID: "public.news", permission: PermissionRead, expected: true},
+		{name: "any subject read only", subject: "someone", chID: "public.news", permission: PermissionWrite},
+		{name: "unknown subject", subject: "someone", chID: "orders.1", permission: PermissionRead},
+		{name: "malformed pattern never matches", subject: "reader", chID: "[", permission: PermissionRead},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allowed := policy.Allowed(&Identity{Subject: test.subject}, test.chID, test.permission)
+			if allowed != test.expected {
+				t.Fatalf("got allowed %t, expected %t", allowed, test.expected)
+			}
+		})
+	}
+}
+
+func TestPolicyDeniesAnonymous(t *testing.T) {
+	policy := NewPolicy([]Rule{{Subject: AnySubject, Pattern: "*", Read: true, Write: true}})
+	if policy.Allowed(nil, "orders.1", PermissionRead) {
+		t.Fatal("anonymous caller allowed, expected denied")
+	}
+}
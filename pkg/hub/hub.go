@@ -0,0 +1,366 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DefaultBufferSize = 16
+)
+
+// Policy decides what happens to a value when a subscriber's queue is full.
+type Policy string
+
+const (
+	// PolicyDropOldest discards the oldest queued value to make room for the new one.
+	PolicyDropOldest Policy = "drop-oldest"
+	// PolicyDropNewest discards the new value and keeps the queue as it is.
+	PolicyDropNewest Policy = "drop-newest"
+	// PolicyCoalesce discards every queued value and keeps only the new one,
+	// for values that each carry the full latest state.
+	PolicyCoalesce Policy = "coalesce"
+	// PolicyDisconnect removes the subscriber, whose Err then reports ErrSlowConsumer.
+	PolicyDisconnect Policy = "disconnect"
+)
+
+var (
+	ErrSlowConsumer  = errors.New("subscriber queue overflowed")
+	ErrUnknownPolicy = errors.New("unknown overflow policy")
+)
+
+type (
+	// OpenFunc opens the upstream of a topic. The returned channel must be
+	// closed once ctx is canceled or the upstream fails.
+	OpenFunc[T any] func(ctx context.Context, key string) (<-chan T, error)
+
+	// Hub shares a single upstream per topic key between every local subscriber.
+	// The upstream is opened by the first subscriber and torn down when the last
+	// one leaves, every value it yields is fanned out to all subscribers.
+	Hub[T any] struct {
+		open   OpenFunc[T]
+		config Config
+
+		mu     sync.Mutex
+		topics map[string]*topic[T]
+	}
+
+	// Subscriber receives the values of a topic on its own bounded queue.
+	Subscriber[T any] struct {
+		hub    *Hub[T]
+		topic  *topic[T]
+		policy Policy
+		ch     chan T
+		err    error
+		once   sync.Once
+	}
+
+	Config struct {
+		// BufferSize is the capacity of each subscriber's queue.
+		BufferSize int
+		// Overflow is the policy of subscribers that do not choose their own.
+		Overflow Policy
+	}
+
+	// Stats are the delivery counters of a topic since its upstream was opened.
+	Stats struct {
+		Subscribers  int    `json:"subscribers"`
+		Delivered    uint64 `json:"delivered"`
+		Dropped      uint64 `json:"dropped"`
+		Disconnected uint64 `json:"disconnected"`
+	}
+
+	topic[T any] struct {
+		key         string
+		cancel      context.CancelFunc
+		ready       chan struct{}
+		err         error
+		subscribers map[*Subscriber[T]]struct{}
+		stats       counters
+	}
+
+	counters struct {
+		delivered    atomic.Uint64
+		dropped      atomic.Uint64
+		disconnected atomic.Uint64
+	}
+)
+
+// ParsePolicy validates the name of an overflow policy.
+func ParsePolicy(name string) (Policy, error) {
+	switch policy := Policy(name); policy {
+	case PolicyDropOldest, PolicyDropNewest, PolicyCoalesce, PolicyDisconnect:
+		return policy, nil
+	default:
+		return "", ErrUnknownPolicy
+	}
+}
+
+func New[T any](open OpenFunc[T], config Config) *Hub[T] {
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+	if config.Overflow == "" {
+		config.Overflow = PolicyDropOldest
+	}
+
+	return &Hub[T]{
+		open:   open,
+		config: config,
+		topics: make(map[string]*topic[T]),
+	}
+}
+
+// Subscribe joins the topic with the default overflow policy of the hub.
+func (h *Hub[T]) Subscribe(key string) (*Subscriber[T], error) {
+	return h.SubscribeWithPolicy(key, "")
+}
+
+// SubscribeWithPolicy joins the topic, opening its upstream if this is the first
+// subscriber. An empty policy selects the default of the hub. The subscriber must
+// be closed once it is no longer read.
+func (h *Hub[T]) SubscribeWithPolicy(key string, policy Policy) (*Subscriber[T], error) {
+	if policy == "" {
+		policy = h.config.Overflow
+	}
+
+	h.mu.Lock()
+	t, exists := h.topics[key]
+	if !exists {
+		t = &topic[T]{
+			key:         key,
+			ready:       make(chan struct{}),
+			subscribers: make(map[*Subscriber[T]]struct{}),
+		}
+		h.topics[key] = t
+	}
+
+	sub := &Subscriber[T]{
+		hub:    h,
+		topic:  t,
+		policy: policy,
+		ch:     make(chan T, h.config.BufferSize),
+	}
+	t.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+
+	if !exists {
+		h.start(t)
+	}
+
+	<-t.ready
+	if t.err != nil {
+		sub.Close()
+		return nil, t.err
+	}
+
+	return sub, nil
+}
+
+// Stats returns the counters of every open topic. They are dropped along with the
+// topic when its last subscriber leaves, so the keys stay bounded by the subscriptions.
+func (h *Hub[T]) Stats() map[string]Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := make(map[string]Stats, len(h.topics))
+	for key, t := range h.topics {
+		stats[key] = Stats{
+			Subscribers:  len(t.subscribers),
+			Delivered:    t.stats.delivered.Load(),
+			Dropped:      t.stats.dropped.Load(),
+			Disconnected: t.stats.disconnected.Load(),
+		}
+	}
+
+	return stats
+}
+
+// start opens the upstream of the topic and begins dispatching its values.
+func (h *Hub[T]) start(t *topic[T]) {
+	defer close(t.ready)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	h.mu.Lock()
+	t.cancel = cancel
+	h.mu.Unlock()
+
+	upstream, err := h.open(ctx, t.key)
+	if err != nil {
+		cancel()
+		t.err = err
+		h.remove(t)
+		return
+	}
+
+	log.Printf("Hub opened upstream for topic %s", t.key)
+
+	go h.dispatch(t, upstream)
+}
+
+// dispatch fans every upstream value out to the current subscribers until the
+// upstream closes, then ends the queues of the subscribers that are left.
+// It never blocks on a subscriber, a full queue is handled by its policy.
+func (h *Hub[T]) dispatch(t *topic[T], upstream <-chan T) {
+	for value := range upstream {
+		for _, sub := range h.snapshot(t) {
+			h.deliver(sub, value, &t.stats)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.topics[t.key] == t {
+		delete(h.topics, t.key)
+	}
+	for sub := range t.subscribers {
+		close(sub.ch)
+	}
+	t.subscribers = nil
+
+	log.Printf("Hub closed upstream for topic %s", t.key)
+}
+
+// deliver queues the value for the subscriber, applying its overflow policy when
+// the queue is full. Only the dispatcher sends on the queue, so once room is made
+// the following send cannot block.
+func (h *Hub[T]) deliver(sub *Subscriber[T], value T, stats *counters) {
+	select {
+	case sub.ch <- value:
+		stats.delivered.Add(1)
+		return
+	default:
+	}
+
+	switch sub.policy {
+	case PolicyDropNewest:
+		stats.dropped.Add(1)
+		return
+
+	case PolicyDisconnect:
+		if h.disconnect(sub) {
+			stats.disconnected.Add(1)
+		}
+		return
+
+	case PolicyCoalesce:
+		for drained := false; !drained; {
+			select {
+			case <-sub.ch:
+				stats.dropped.Add(1)
+			default:
+				drained = true
+			}
+		}
+
+	default:
+		select {
+		case <-sub.ch:
+			stats.dropped.Add(1)
+		default:
+		}
+	}
+
+	select {
+	case sub.ch <- value:
+		stats.delivered.Add(1)
+	default:
+		stats.dropped.Add(1)
+	}
+}
+
+// disconnect removes a subscriber that could not keep up and ends its queue. It reports
+// false when the subscriber was already gone, e.g. closed concurrently.
+func (h *Hub[T]) disconnect(sub *Subscriber[T]) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.detach(sub) {
+		return false
+	}
+
+	sub.err = ErrSlowConsumer
+	close(sub.ch)
+
+	log.Printf("Hub disconnected slow subscriber of topic %s", sub.topic.key)
+	return true
+}
+
+// snapshot copies the current subscribers of the topic.
+func (h *Hub[T]) snapshot(t *topic[T]) []*Subscriber[T] {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := make([]*Subscriber[T], 0, len(t.subscribers))
+	for sub := range t.subscribers {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// remove detaches the topic from the hub if it is still registered.
+func (h *Hub[T]) remove(t *topic[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.topics[t.key] == t {
+		delete(h.topics, t.key)
+	}
+}
+
+// unsubscribe removes the subscriber from its topic.
+func (h *Hub[T]) unsubscribe(sub *Subscriber[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.detach(sub)
+}
+
+// detach removes the subscriber and tears down the upstream of the topic when it
+// was the last one. It reports whether the subscriber was still attached.
+// The caller must hold the lock.
+func (h *Hub[T]) detach(sub *Subscriber[T]) bool {
+	t := sub.topic
+	if _, ok := t.subscribers[sub]; !ok {
+		return false
+	}
+
+	delete(t.subscribers, sub)
+	if len(t.subscribers) > 0 {
+		return true
+	}
+
+	if h.topics[t.key] == t {
+		delete(h.topics, t.key)
+	}
+	if t.cancel != nil {
+		t.cancel()
+	}
+
+	return true
+}
+
+// C returns the queue of the subscriber. It is closed when the upstream ends
+// or when the subscriber is disconnected for being too slow.
+func (s *Subscriber[T]) C() <-chan T {
+	return s.ch
+}
+
+// Err reports why the queue was closed. It returns ErrSlowConsumer when the
+// subscriber was disconnected by its overflow policy, nil otherwise.
+// It is only meaningful once C is closed.
+func (s *Subscriber[T]) Err() error {
+	return s.err
+}
+
+// Close leaves the topic. It is safe to call more than once.
+func (s *Subscriber[T]) Close() {
+	s.once.Do(func() {
+		s.hub.unsubscribe(s)
+	})
+}
@@ -0,0 +1,194 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	testKey     = "orders.1"
+	testTimeout = 2 * time.Second
+)
+
+// fakeUpstream feeds the values the test pushes to the topic opened by the hub.
+type fakeUpstream struct {
+	values chan int
+}
+
+func newFakeUpstream() *fakeUpstream {
+	return &fakeUpstream{values: make(chan int)}
+}
+
+// open is the OpenFunc of the hub, closing the upstream once ctx is canceled.
+func (f *fakeUpstream) open(ctx context.Context, key string) (<-chan int, error) {
+	upstream := make(chan int)
+
+	go func() {
+		defer close(upstream)
+		for {
+			select {
+			case value := <-f.values:
+				select {
+				case upstream <- value:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return upstream, nil
+}
+
+// push hands the values to the hub one at a time.
+func (f *fakeUpstream) push(t *testing.T, values ...int) {
+	t.Helper()
+
+	for _, value := range values {
+		select {
+		case f.values <- value:
+		case <-time.After(testTimeout):
+			t.Fatalf("value %d not read by the upstream in time", value)
+		}
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy   Policy
+		queued   []int
+		expected Stats
+	}{
+		{
+			policy:   PolicyDropOldest,
+			queued:   []int{4, 5},
+			expected: Stats{Subscribers: 1, Delivered: 5, Dropped: 3},
+		},
+		{
+			policy:   PolicyDropNewest,
+			queued:   []int{1, 2},
+			expected: Stats{Subscribers: 1, Delivered: 2, Dropped: 3},
+		},
+		{
+			// The third and fifth values each find a full queue and replace it
+			policy:   PolicyCoalesce,
+			queued:   []int{5},
+			expected: Stats{Subscribers: 1, Delivered: 5, Dropped: 4},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			upstream := newFakeUpstream()
+			h := New(upstream.open, Config{BufferSize: 2})
+
+			sub, err := h.SubscribeWithPolicy(testKey, test.policy)
+			if err != nil {
+				t.Fatalf("SubscribeWithPolicy: %v", err)
+			}
+			defer sub.Close()
+
+			upstream.push(t, 1, 2, 3, 4, 5)
+			waitStats(t, h, test.expected)
+
+			if queued := drain(sub); !slices.Equal(queued, test.queued) {
+				t.Fatalf("got queue %v, expected %v", queued, test.queued)
+			}
+		})
+	}
+}
+
+func TestOverflowPolicyDisconnect(t *testing.T) {
+	upstream := newFakeUpstream()
+	h := New(upstream.open, Config{BufferSize: 2})
+
+	// Another subscriber keeps the topic open once the slow one is gone
+	other, err := h.Subscribe(testKey)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer other.Close()
+
+	sub, err := h.SubscribeWithPolicy(testKey, PolicyDisconnect)
+	if err != nil {
+		t.Fatalf("SubscribeWithPolicy: %v", err)
+	}
+	defer sub.Close()
+
+	upstream.push(t, 1, 2, 3, 4, 5)
+
+	// The slow subscriber got the first two values, the other one every value and
+	// dropped the three oldest
+	waitStats(t, h, Stats{Subscribers: 1, Delivered: 7, Dropped: 3, Disconnected: 1})
+
+	if queued := drain(sub); !slices.Equal(queued, []int{1, 2}) {
+		t.Fatalf("got queue %v, expected [1 2]", queued)
+	}
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("queue of the disconnected subscriber still open")
+	}
+	if !errors.Is(sub.Err(), ErrSlowConsumer) {
+		t.Fatalf("got error %v, expected %v", sub.Err(), ErrSlowConsumer)
+	}
+
+	// Closing the disconnected subscriber leaves the counters as they are
+	sub.Close()
+	waitStats(t, h, Stats{Subscribers: 1, Delivered: 7, Dropped: 3, Disconnected: 1})
+}
+
+func TestStatsDroppedWithTopic(t *testing.T) {
+	upstream := newFakeUpstream()
+	h := New(upstream.open, Config{})
+
+	sub, err := h.Subscribe(testKey)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	upstream.push(t, 1)
+	waitStats(t, h, Stats{Subscribers: 1, Delivered: 1})
+
+	sub.Close()
+	if stats, ok := h.Stats()[testKey]; ok {
+		t.Fatalf("got stats %+v after the last subscriber left, expected none", stats)
+	}
+}
+
+// waitStats polls the stats of the topic until they match, failing the test when
+// they do not in time.
+func waitStats(t *testing.T, h *Hub[int], expected Stats) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		stats := h.Stats()[testKey]
+		if stats == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got stats %+v, expected %+v", stats, expected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// drain reads the values queued for the subscriber without waiting for more.
+func drain(sub *Subscriber[int]) []int {
+	var values []int
+	for {
+		select {
+		case value, ok := <-sub.C():
+			if !ok {
+				return values
+			}
+			values = append(values, value)
+		default:
+			return values
+		}
+	}
+}
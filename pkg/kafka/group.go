@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+)
+
+// Group scopes decide which consumers share a consumer group, and so split the
+// partitions of its topics between them.
+const (
+	// GroupScopeShared shares the group between every consumer of every instance,
+	// each message is handled once overall.
+	GroupScopeShared = "shared"
+	// GroupScopeInstance gives each instance its own group, each message is handled
+	// once per instance.
+	GroupScopeInstance = "instance"
+	// GroupScopeSubscriber gives each consumer its own group, each message is handled
+	// by every consumer. Broadcast does the same without leaving groups behind.
+	GroupScopeSubscriber = "subscriber"
+)
+
+var (
+	ErrUnknownGroupScope = errors.New("unknown consumer group scope")
+)
+
+// GroupName derives the name of a consumer group from a base name and a scope, the
+// shared scope when empty. Instance groups are suffixed with the hostname, subscriber
+// groups with the hostname and a random ID, so they must be derived per consumer.
+func GroupName(base, scope string) (string, error) {
+	switch scope {
+	case GroupScopeShared, "":
+		return base, nil
+
+	case GroupScopeInstance:
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", err
+		}
+		return base + "-" + hostname, nil
+
+	case GroupScopeSubscriber:
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", err
+		}
+
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return "", err
+		}
+		return base + "-" + hostname + "-" + hex.EncodeToString(id), nil
+
+	default:
+		return "", ErrUnknownGroupScope
+	}
+}
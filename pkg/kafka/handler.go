@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// sessionRetry spaces the sessions of a handler that keeps failing, e.g. while a store
+// it depends on is down, so the same messages are not refetched in a tight loop.
+var sessionRetry = RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+type (
+	// Handler processes the messages of the partitions claimed by a consumer group
+	// member. Unlike Consume, nothing is committed unless the handler marks it through
+	// the session, or records it in a transaction with TransactionalProducer.SendOffsets.
+	// An error is logged and ends the session: the partitions are claimed again, with a
+	// growing delay while errors go on, and the unmarked messages are redelivered.
+	//
+	// The messages of a partition are handled in order, but the partitions are handled
+	// concurrently, each from its own goroutine, so a handler must be safe for concurrent
+	// use. In particular, a TransactionalProducer runs one transaction at a time and must
+	// not be shared between partitions without serializing its transactions.
+	Handler func(session Session, msg *Message) error
+
+	// Session is the membership of a consumer in its group, which lasts until the
+	// next rebalance.
+	Session interface {
+		Context() context.Context
+		Group() string
+		Mark(msg *Message)
+		Commit()
+	}
+
+	HandlerConfig struct {
+		// OffsetOption is where partitions without a committed offset are read from.
+		OffsetOption int
+		// ReadCommitted skips the messages of aborted or pending transactions.
+		ReadCommitted bool
+		// AutoCommit commits the marked messages periodically, instead of on Commit only.
+		AutoCommit bool
+	}
+
+	session struct {
+		sess  sarama.ConsumerGroupSession
+		group string
+	}
+
+	handlerGroup struct {
+		handler    Handler
+		group      string
+		endSession context.CancelFunc
+		failed     atomic.Bool
+	}
+)
+
+// ConsumeWithHandler joins the consumer group and hands every message of the topics to
+// the handler until ctx is canceled or the client is closed. The partitions are handled
+// concurrently, the messages of each one in order.
+func (r *client) ConsumeWithHandler(ctx context.Context, topics []string, group string, config HandlerConfig, handler Handler) error {
+	consumerGroup, err := newConsumerGroup(r.config, group, config.OffsetOption, func(kafkaConfig *sarama.Config) {
+		kafkaConfig.Consumer.Offsets.AutoCommit.Enable = config.AutoCommit
+		if config.ReadCommitted {
+			kafkaConfig.Consumer.IsolationLevel = sarama.ReadCommitted
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.consumerGroups[consumerGroup] = struct{}{}
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.consumerGroups, consumerGroup)
+		r.mu.Unlock()
+
+		consumerGroup.Close()
+	}()
+
+	failures := 0
+	for ctx.Err() == nil {
+		// Sarama only stops the partition of a failed claim, the whole session is ended
+		// instead so its partitions are claimed again
+		sessionCtx, endSession := context.WithCancel(ctx)
+		consumer := &handlerGroup{handler: handler, group: group, endSession: endSession}
+
+		err := consumerGroup.Consume(sessionCtx, topics, consumer)
+		endSession()
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Error from consumer: %v", err)
+		}
+
+		if err == nil && !consumer.failed.Load() {
+			failures = 0
+			continue
+		}
+
+		failures++
+		select {
+		case <-time.After(sessionRetry.Delay(failures)):
+		case <-ctx.Done():
+		}
+	}
+
+	return nil
+}
+
+func (h *handlerGroup) Setup(_ sarama.ConsumerGroupSession) error { return nil }
+
+func (h *handlerGroup) Cleanup(_ sarama.ConsumerGroupSession) error { return nil }
+
+func (h *handlerGroup) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	s := &session{sess: sess, group: h.group}
+
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			// The consumer group logs the error along with the partition
+			if err := h.handler(s, toMessage(msg)); err != nil {
+				h.failed.Store(true)
+				h.endSession()
+				return fmt.Errorf("handling message at offset %d: %w", msg.Offset, err)
+			}
+
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Context is canceled when the session ends, e.g. on a rebalance.
+func (s *session) Context() context.Context {
+	return s.sess.Context()
+}
+
+func (s *session) Group() string {
+	return s.group
+}
+
+// Mark records the message as processed, to be committed along with the previous ones.
+func (s *session) Mark(msg *Message) {
+	s.sess.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, "")
+}
+
+// Commit synchronously commits the marked messages.
+func (s *session) Commit() {
+	s.sess.Commit()
+}
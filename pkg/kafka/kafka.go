@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/IBM/sarama"
@@ -19,15 +21,24 @@ const (
 
 type (
 	Client interface {
-		Produce(topic string, message interface{}) error
+		Produce(ctx context.Context, topic string, key string, headers map[string]string, message interface{}) error
+		ProduceAsync(ctx context.Context, topic string, key string, headers map[string]string, message interface{}, onDelivery func(err error)) error
 		Consume(ctx context.Context, topics []string, offsetOption int, consumerGroup string) (<-chan *Message, error)
+		Offsets(topic string, position int64) (map[int32]int64, error)
+		Partition(topic string, key string) (int32, error)
+		ConsumePartitions(ctx context.Context, topic string, from, until map[int32]int64) (<-chan *Message, error)
+		Broadcast(ctx context.Context, topics []string, offsetOption int) (<-chan *Message, error)
+		ConsumeWithHandler(ctx context.Context, topics []string, group string, config HandlerConfig, handler Handler) error
+		NewTransactionalProducer(transactionalID string) (TransactionalProducer, error)
 		Close() error
 	}
 
 	client struct {
-		producer      sarama.SyncProducer
-		consumerGroup sarama.ConsumerGroup
-		brokers       []string
+		producer       producer
+		timeout        time.Duration
+		config         Config
+		mu             sync.Mutex
+		consumerGroups map[sarama.ConsumerGroup]struct{}
 	}
 
 	Message struct {
@@ -36,19 +47,33 @@ type (
 		Offset    int64
 		Key       []byte
 		Value     []byte
+		Headers   map[string]string
 		Timestamp time.Time
 	}
 
 	Config struct {
-		Brokers   []string
-		Username  string
-		Password  string
+		Brokers  []string
+		Username string
+		Password string
+		// Mechanism is the SASL mechanism the credentials are sent with, SASLPlain when empty.
+		Mechanism string
 		UseTLS    bool
 		TLSConfig *tls.Config // Optional custom TLS configuration
+		// TLS files the TLS configuration is loaded from, when no custom one is set.
+		TLS      TLSFiles
+		Producer ProducerConfig
 	}
 )
 
 func NewClient(config Config) (Client, error) {
+	if config.UseTLS && config.TLSConfig == nil {
+		tlsConfig, err := newTLSConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		config.TLSConfig = tlsConfig
+	}
+
 	producer, err := newProducer(config)
 	if err != nil {
 		return nil, err
@@ -56,46 +81,36 @@ func NewClient(config Config) (Client, error) {
 
 	log.Println("Connected to Kafka successfully.")
 
+	timeout := config.Producer.Timeout
+	if timeout <= 0 {
+		timeout = Timeout * time.Second
+	}
+
 	return &client{
-		producer: producer,
-		brokers:  config.Brokers,
+		producer:       producer,
+		timeout:        timeout,
+		config:         config,
+		consumerGroups: make(map[sarama.ConsumerGroup]struct{}),
 	}, nil
 }
 
-func newSaramaConfig(config Config) *sarama.Config {
+func newSaramaConfig(config Config) (*sarama.Config, error) {
 	kafkaConfig := sarama.NewConfig()
 
-	if config.Username != "" && config.Password != "" {
-		kafkaConfig.Net.SASL.Enable = true
-		kafkaConfig.Net.SASL.User = config.Username
-		kafkaConfig.Net.SASL.Password = config.Password
-		kafkaConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
-	}
-
-	if config.UseTLS {
-		kafkaConfig.Net.TLS.Enable = true
-		kafkaConfig.Net.TLS.Config = config.TLSConfig
+	if err := applySecurityConfig(kafkaConfig, config); err != nil {
+		return nil, err
 	}
 
-	return kafkaConfig
+	return kafkaConfig, nil
 }
 
-// newProducer creates a new Kafka sync producer.
-func newProducer(config Config) (sarama.SyncProducer, error) {
-	kafkaConfig := newSaramaConfig(config)
-	kafkaConfig.Producer.Return.Successes = true
-
-	producer, err := sarama.NewSyncProducer(config.Brokers, kafkaConfig)
+// newConsumerGroup creates a new Kafka consumer group, of which options may adjust
+// the configuration.
+func newConsumerGroup(config Config, group string, offsetOption int, options ...func(kafkaConfig *sarama.Config)) (sarama.ConsumerGroup, error) {
+	kafkaConfig, err := newSaramaConfig(config)
 	if err != nil {
 		return nil, err
 	}
-
-	return producer, nil
-}
-
-// newConsumerGroup creates a new Kafka consumer group.
-func newConsumerGroup(config Config, group string, offsetOption int) (sarama.ConsumerGroup, error) {
-	kafkaConfig := newSaramaConfig(config)
 	kafkaConfig.Consumer.Return.Errors = true
 
 	switch offsetOption {
@@ -107,36 +122,106 @@ func newConsumerGroup(config Config, group string, offsetOption int) (sarama.Con
 		kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
 	}
 
+	for _, option := range options {
+		option(kafkaConfig)
+	}
+
 	consumerGroup, err := sarama.NewConsumerGroup(config.Brokers, group, kafkaConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	// Errors are dropped unless read, including those returned by the handlers
+	go func() {
+		for err := range consumerGroup.Errors() {
+			log.Printf("Error from consumer group %s: %v", group, err)
+		}
+	}()
+
 	return consumerGroup, nil
 }
 
-func (r *client) Produce(topic string, message interface{}) error {
-	_, cancel := context.WithTimeout(context.Background(), Timeout*time.Second)
+// Produce sends the message to the topic along with the headers, and waits until it
+// is acknowledged or ctx is done. Messages sharing a key are sent to the same partition
+// so they keep their order; an empty key spreads them over the partitions. A sync
+// producer cannot be interrupted: when ctx is done first, only the wait is abandoned and
+// the message may still be delivered, so callers must be ready for it to be.
+func (r *client) Produce(ctx context.Context, topic string, key string, headers map[string]string, message interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
-	bData, err := json.Marshal(message)
+	// A sync producer blocks in ProduceAsync, which must not outlive ctx either
+	delivered := make(chan error, 1)
+	go func() {
+		if err := r.ProduceAsync(ctx, topic, key, headers, message, func(err error) {
+			delivered <- err
+		}); err != nil {
+			delivered <- err
+		}
+	}()
+
+	select {
+	case err := <-delivered:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ProduceAsync is Produce without waiting for the acknowledgement with an async producer,
+// which reports it to onDelivery from another goroutine instead. A sync producer reports
+// it before returning. Messages are sent in the order of the calls. ctx only bounds the
+// wait for room in the batches of an async producer.
+func (r *client) ProduceAsync(
+	ctx context.Context,
+	topic string,
+	key string,
+	headers map[string]string,
+	message interface{},
+	onDelivery func(err error),
+) error {
+	msg, err := newProducerMessage(topic, key, headers, message)
 	if err != nil {
 		return err
 	}
 
+	return r.producer.send(ctx, msg, onDelivery)
+}
+
+// newProducerMessage encodes the message as JSON along with its key and headers.
+// A []byte message is sent as is.
+func newProducerMessage(topic string, key string, headers map[string]string, message interface{}) (*sarama.ProducerMessage, error) {
+	bData, ok := message.([]byte)
+	if !ok {
+		var err error
+		if bData, err = json.Marshal(message); err != nil {
+			return nil, err
+		}
+	}
+
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
 		Value: sarama.ByteEncoder(bData),
 	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	for name, value := range headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{
+			Key:   []byte(name),
+			Value: []byte(value),
+		})
+	}
 
-	_, _, err = r.producer.SendMessage(msg)
-	return err
+	return msg, nil
 }
 
+// Consume joins the consumer group, which splits the partitions of the topics between
+// its members, until ctx is canceled. Every call joins the group as a new member.
 func (r *client) Consume(ctx context.Context, topics []string, offsetOption int, consumerGroup string) (<-chan *Message, error) {
 	messages := make(chan *Message)
 
-	consumerGroupClient, err := newConsumerGroup(Config{Brokers: r.brokers}, consumerGroup, offsetOption)
+	consumerGroupClient, err := newConsumerGroup(r.config, consumerGroup, offsetOption)
 	if err != nil {
 		return nil, err
 	}
@@ -146,8 +231,16 @@ func (r *client) Consume(ctx context.Context, topics []string, offsetOption int,
 		offsetOption: offsetOption,
 	}
 
+	r.mu.Lock()
+	r.consumerGroups[consumerGroupClient] = struct{}{}
+	r.mu.Unlock()
+
 	go func() {
 		defer func() {
+			r.mu.Lock()
+			delete(r.consumerGroups, consumerGroupClient)
+			r.mu.Unlock()
+
 			consumerGroupClient.Close()
 			close(messages)
 		}()
@@ -160,6 +253,9 @@ func (r *client) Consume(ctx context.Context, topics []string, offsetOption int,
 			default:
 				if err := consumerGroupClient.Consume(ctx, topics, consumer); err != nil {
 					log.Printf("Error from consumer: %v", err)
+					if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+						return
+					}
 					if ctx.Err() != nil {
 						log.Println("context error detected, stopping consumption.")
 						return
@@ -169,20 +265,22 @@ func (r *client) Consume(ctx context.Context, topics []string, offsetOption int,
 		}
 	}()
 
-	r.consumerGroup = consumerGroupClient
-
 	return messages, nil
 }
 
 func (r *client) Close() error {
-	if err := r.producer.Close(); err != nil {
-		return err
+	// Every consumer group is closed even when the producer or another group fails to
+	errs := []error{r.producer.close()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for consumerGroup := range r.consumerGroups {
+		errs = append(errs, consumerGroup.Close())
 	}
 
-	if r.consumerGroup != nil {
-		if err := r.consumerGroup.Close(); err != nil {
-			return err
-		}
+	if err := errors.Join(errs...); err != nil {
+		return err
 	}
 
 	log.Println("Disconnected from Kafka.")
@@ -201,13 +299,10 @@ func (h *consumerGroupHandler) Cleanup(_ sarama.ConsumerGroupSession) error { re
 
 func (h *consumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for msg := range claim.Messages() {
-		h.messages <- &Message{
-			Topic:     msg.Topic,
-			Partition: msg.Partition,
-			Offset:    msg.Offset,
-			Key:       msg.Key,
-			Value:     msg.Value,
-			Timestamp: msg.Timestamp,
+		select {
+		case h.messages <- toMessage(msg):
+		case <-sess.Context().Done():
+			return nil
 		}
 		sess.MarkMessage(msg, "")
 	}
@@ -0,0 +1,264 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	// OffsetOldest and OffsetNewest resolve to the first offset still held by a
+	// partition and to the offset of the next message produced to it.
+	OffsetOldest = sarama.OffsetOldest
+	OffsetNewest = sarama.OffsetNewest
+
+	// unbounded is the end of a partition claim read until canceled.
+	unbounded = -1
+
+	// claimIdleTimeout is how long a bounded claim waits for a message, several times
+	// the fetch wait of the brokers, before its partition is deemed read up to the end.
+	claimIdleTimeout = 2 * time.Second
+)
+
+// Offsets resolves a position in each partition of the topic. The position is
+// OffsetOldest, OffsetNewest, or a time in milliseconds since the epoch, which resolves
+// to the first message produced at or after it, or to OffsetNewest when there is none.
+func (r *client) Offsets(topic string, position int64) (map[int32]int64, error) {
+	saramaClient, err := r.newSaramaClient()
+	if err != nil {
+		return nil, err
+	}
+	defer saramaClient.Close()
+
+	partitions, err := saramaClient.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		offset, err := saramaClient.GetOffset(topic, partition, position)
+		if err == nil && offset < 0 {
+			offset, err = saramaClient.GetOffset(topic, partition, OffsetNewest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		offsets[partition] = offset
+	}
+
+	return offsets, nil
+}
+
+// Partition returns the partition of the topic the messages with the key are produced
+// to, as chosen by the hash partitioner of the producer, so their history is only read
+// from there.
+func (r *client) Partition(topic string, key string) (int32, error) {
+	saramaClient, err := r.newSaramaClient()
+	if err != nil {
+		return 0, err
+	}
+	defer saramaClient.Close()
+
+	partitions, err := saramaClient.Partitions(topic)
+	if err != nil {
+		return 0, err
+	}
+
+	partitioner := sarama.NewHashPartitioner(topic)
+	choice, err := partitioner.Partition(&sarama.ProducerMessage{Topic: topic, Key: sarama.StringEncoder(key)}, int32(len(partitions)))
+	if err != nil {
+		return 0, err
+	}
+	return partitions[choice], nil
+}
+
+// ConsumePartitions reads the topic without a consumer group, so every caller receives
+// every message. Each partition in from is read from the given offset, and stops before
+// the offset given in until, or never when until is nil. A partition of which the last
+// offsets hold no message, e.g. transaction markers, stops once idle for a while. The
+// channel is closed once every partition is read or ctx is canceled.
+func (r *client) ConsumePartitions(ctx context.Context, topic string, from, until map[int32]int64) (<-chan *Message, error) {
+	var claims []partitionClaim
+	for partition, offset := range from {
+		end, bounded := until[partition]
+		if until == nil {
+			end = unbounded
+		} else if !bounded || offset >= end {
+			continue
+		}
+
+		claims = append(claims, partitionClaim{topic: topic, partition: partition, offset: offset, end: end})
+	}
+
+	return r.consumePartitions(ctx, func(sarama.Client) ([]partitionClaim, error) {
+		return claims, nil
+	})
+}
+
+// Broadcast reads every partition of the topics without a consumer group, so that
+// every caller receives every message, unlike Consume which splits the partitions
+// between the members of a group. Reading starts at the newest or the oldest offset
+// of each partition depending on offsetOption, and goes on until ctx is canceled.
+func (r *client) Broadcast(ctx context.Context, topics []string, offsetOption int) (<-chan *Message, error) {
+	offset := OffsetNewest
+	if offsetOption == OffsetFromEarliest {
+		offset = OffsetOldest
+	}
+
+	return r.consumePartitions(ctx, func(saramaClient sarama.Client) ([]partitionClaim, error) {
+		var claims []partitionClaim
+		for _, topic := range topics {
+			partitions, err := saramaClient.Partitions(topic)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, partition := range partitions {
+				claims = append(claims, partitionClaim{topic: topic, partition: partition, offset: offset, end: unbounded})
+			}
+		}
+		return claims, nil
+	})
+}
+
+type partitionClaim struct {
+	topic     string
+	partition int32
+	offset    int64
+	end       int64
+}
+
+// consumePartitions reads the claimed partitions into a single channel, which is closed
+// once every claim reached its end or ctx is canceled.
+func (r *client) consumePartitions(
+	ctx context.Context,
+	claim func(saramaClient sarama.Client) ([]partitionClaim, error),
+) (<-chan *Message, error) {
+	saramaClient, err := r.newSaramaClient()
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(saramaClient)
+	if err != nil {
+		saramaClient.Close()
+		return nil, err
+	}
+
+	var partitionConsumers []sarama.PartitionConsumer
+	closeAll := func() {
+		for _, pc := range partitionConsumers {
+			pc.Close()
+		}
+		consumer.Close()
+		saramaClient.Close()
+	}
+
+	claims, err := claim(saramaClient)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	for _, c := range claims {
+		pc, err := consumer.ConsumePartition(c.topic, c.partition, c.offset)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		partitionConsumers = append(partitionConsumers, pc)
+	}
+
+	messages := make(chan *Message)
+
+	var wg sync.WaitGroup
+	for i, pc := range partitionConsumers {
+		wg.Add(1)
+		go func(pc sarama.PartitionConsumer, end int64) {
+			defer wg.Done()
+
+			// The offsets before the end may hold no message, as transaction markers and
+			// records removed by compaction are never delivered. A bounded claim also
+			// ends once no message arrives while the partition holds the end already.
+			var idle <-chan time.Time
+			if end != unbounded {
+				ticker := time.NewTicker(claimIdleTimeout)
+				defer ticker.Stop()
+				idle = ticker.C
+			}
+			lastMessage := time.Now()
+
+			for {
+				select {
+				case msg, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					if end != unbounded && msg.Offset >= end {
+						return
+					}
+
+					select {
+					case messages <- toMessage(msg):
+					case <-ctx.Done():
+						return
+					}
+
+					if end != unbounded && msg.Offset+1 >= end {
+						return
+					}
+					lastMessage = time.Now()
+
+				case <-idle:
+					if time.Since(lastMessage) >= claimIdleTimeout && pc.HighWaterMarkOffset() >= end {
+						return
+					}
+
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(pc, claims[i].end)
+	}
+
+	go func() {
+		wg.Wait()
+		closeAll()
+		close(messages)
+	}()
+
+	return messages, nil
+}
+
+func toMessage(msg *sarama.ConsumerMessage) *Message {
+	var headers map[string]string
+	if len(msg.Headers) > 0 {
+		headers = make(map[string]string, len(msg.Headers))
+		for _, header := range msg.Headers {
+			headers[string(header.Key)] = string(header.Value)
+		}
+	}
+
+	return &Message{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// newSaramaClient connects to the brokers with the configuration of the client.
+func (r *client) newSaramaClient() (sarama.Client, error) {
+	kafkaConfig, err := newSaramaConfig(r.config)
+	if err != nil {
+		return nil, err
+	}
+
+	return sarama.NewClient(r.config.Brokers, kafkaConfig)
+}
@@ -0,0 +1,196 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Compression codecs and acknowledgement levels a producer can be configured with.
+const (
+	CompressionNone   = "none"
+	CompressionGzip   = "gzip"
+	CompressionSnappy = "snappy"
+	CompressionLZ4    = "lz4"
+	CompressionZstd   = "zstd"
+
+	// AcksNone does not wait for the broker, AcksLeader waits for the partition leader,
+	// AcksAll waits for every in-sync replica.
+	AcksNone   = "none"
+	AcksLeader = "leader"
+	AcksAll    = "all"
+)
+
+var (
+	ErrUnknownCompression = errors.New("unknown compression codec")
+	ErrUnknownAcks        = errors.New("unknown acknowledgement level")
+)
+
+type (
+	ProducerConfig struct {
+		// Async batches messages in the background instead of sending them one by one.
+		Async bool
+		// Linger is how long an async producer waits for a batch to fill before sending it.
+		Linger time.Duration
+		// BatchBytes and BatchMessages send an async batch as soon as it holds that
+		// many bytes or messages, when positive.
+		BatchBytes    int
+		BatchMessages int
+		// Compression codec of the batches, CompressionNone when empty.
+		Compression string
+		// Idempotent prevents the retries of the producer from duplicating messages.
+		// It requires AcksAll.
+		Idempotent bool
+		// Acks is the acknowledgement level, AcksLeader when empty, AcksAll when idempotent.
+		Acks string
+		// Timeout bounds each call to Produce whose context has no earlier deadline,
+		// Timeout seconds when zero.
+		Timeout time.Duration
+	}
+
+	// producer sends messages in the order of the calls to send, and reports their
+	// delivery to onDelivery, which is called once per message.
+	producer interface {
+		send(ctx context.Context, msg *sarama.ProducerMessage, onDelivery func(err error)) error
+		close() error
+	}
+
+	syncProducer struct {
+		producer sarama.SyncProducer
+	}
+
+	asyncProducer struct {
+		producer sarama.AsyncProducer
+		reports  sync.WaitGroup
+	}
+)
+
+// applyProducerConfig sets up the producer part of the sarama configuration.
+func applyProducerConfig(kafkaConfig *sarama.Config, config ProducerConfig) error {
+	kafkaConfig.Producer.Return.Successes = true
+	kafkaConfig.Producer.Return.Errors = true
+
+	// A sync producer waits for each message, lingering would only delay it
+	if config.Async {
+		kafkaConfig.Producer.Flush.Frequency = config.Linger
+		kafkaConfig.Producer.Flush.Bytes = config.BatchBytes
+		kafkaConfig.Producer.Flush.Messages = config.BatchMessages
+	}
+
+	switch config.Compression {
+	case CompressionNone, "":
+		kafkaConfig.Producer.Compression = sarama.CompressionNone
+	case CompressionGzip:
+		kafkaConfig.Producer.Compression = sarama.CompressionGZIP
+	case CompressionSnappy:
+		kafkaConfig.Producer.Compression = sarama.CompressionSnappy
+	case CompressionLZ4:
+		kafkaConfig.Producer.Compression = sarama.CompressionLZ4
+	case CompressionZstd:
+		kafkaConfig.Producer.Compression = sarama.CompressionZSTD
+	default:
+		return ErrUnknownCompression
+	}
+
+	acks := config.Acks
+	if acks == "" && config.Idempotent {
+		acks = AcksAll
+	}
+
+	switch acks {
+	case AcksLeader, "":
+		kafkaConfig.Producer.RequiredAcks = sarama.WaitForLocal
+	case AcksNone:
+		kafkaConfig.Producer.RequiredAcks = sarama.NoResponse
+	case AcksAll:
+		kafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	default:
+		return ErrUnknownAcks
+	}
+
+	if config.Idempotent {
+		kafkaConfig.Producer.Idempotent = true
+		kafkaConfig.Net.MaxOpenRequests = 1
+	}
+
+	return nil
+}
+
+// newProducer creates the sync or async producer chosen in the configuration.
+func newProducer(config Config) (producer, error) {
+	kafkaConfig, err := newSaramaConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyProducerConfig(kafkaConfig, config.Producer); err != nil {
+		return nil, err
+	}
+
+	if !config.Producer.Async {
+		p, err := sarama.NewSyncProducer(config.Brokers, kafkaConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &syncProducer{producer: p}, nil
+	}
+
+	p, err := sarama.NewAsyncProducer(config.Brokers, kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &asyncProducer{producer: p}
+	a.reports.Add(2)
+	go func() {
+		defer a.reports.Done()
+		for msg := range p.Successes() {
+			msg.Metadata.(func(error))(nil)
+		}
+	}()
+	go func() {
+		defer a.reports.Done()
+		for err := range p.Errors() {
+			err.Msg.Metadata.(func(error))(err.Err)
+		}
+	}()
+
+	return a, nil
+}
+
+// send blocks until the message is acknowledged, as a sync producer does.
+func (p *syncProducer) send(ctx context.Context, msg *sarama.ProducerMessage, onDelivery func(err error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, _, err := p.producer.SendMessage(msg)
+	onDelivery(err)
+	return nil
+}
+
+func (p *syncProducer) close() error {
+	return p.producer.Close()
+}
+
+// send queues the message in the current batch, waiting for room until ctx is done.
+// Its delivery is reported from another goroutine.
+func (p *asyncProducer) send(ctx context.Context, msg *sarama.ProducerMessage, onDelivery func(err error)) error {
+	msg.Metadata = onDelivery
+
+	select {
+	case p.producer.Input() <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close flushes the pending batches and waits for their delivery to be reported.
+func (p *asyncProducer) close() error {
+	err := p.producer.Close()
+	p.reports.Wait()
+	return err
+}
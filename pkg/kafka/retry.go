@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"strconv"
+	"time"
+)
+
+// Headers describing why a message was forwarded to a retry or dead-letter topic.
+const (
+	HeaderError             = "x-error"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderAttempts          = "x-attempts"
+	HeaderFailedAt          = "x-failed-at"
+	HeaderRetryAt           = "x-retry-at"
+)
+
+// RetryPolicy spaces the attempts to process a message with an exponential backoff.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a message is processed before giving up,
+	// once when not positive.
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, doubled before each next one.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between two attempts, when positive.
+	MaxBackoff time.Duration
+}
+
+// Delay returns how long to wait before the next attempt, after the given number of
+// failed attempts.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return delay
+}
+
+// Exhausted reports whether no attempt is left after the given number of failed ones.
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return attempts >= max(p.MaxAttempts, 1)
+}
+
+// Attempts returns how many times a message was already processed, as recorded in its
+// headers when it was forwarded to a retry topic.
+func Attempts(msg *Message) int {
+	attempts, _ := strconv.Atoi(msg.Headers[HeaderAttempts])
+	return attempts
+}
+
+// RetryAt returns when a message forwarded to a retry topic is due, or the zero time.
+func RetryAt(msg *Message) time.Time {
+	ms, err := strconv.ParseInt(msg.Headers[HeaderRetryAt], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// Origin returns where a message was first produced, as recorded in its headers when it
+// was forwarded to a retry topic, or its own coordinates otherwise.
+func Origin(msg *Message) (topic string, partition int32, offset int64) {
+	topic = msg.Headers[HeaderOriginalTopic]
+	p, errPartition := strconv.ParseInt(msg.Headers[HeaderOriginalPartition], 10, 32)
+	o, errOffset := strconv.ParseInt(msg.Headers[HeaderOriginalOffset], 10, 64)
+	if topic == "" || errPartition != nil || errOffset != nil {
+		return msg.Topic, msg.Partition, msg.Offset
+	}
+	return topic, int32(p), o
+}
+
+// FailureHeaders returns the headers of the message along with the error and where the
+// message originally comes from, to forward it to a retry or dead-letter topic.
+func FailureHeaders(msg *Message, cause error, attempts int) map[string]string {
+	headers := make(map[string]string, len(msg.Headers)+6)
+	for name, value := range msg.Headers {
+		headers[name] = value
+	}
+
+	// A message forwarded again keeps pointing at where it was first produced
+	if _, ok := headers[HeaderOriginalTopic]; !ok {
+		headers[HeaderOriginalTopic] = msg.Topic
+		headers[HeaderOriginalPartition] = strconv.FormatInt(int64(msg.Partition), 10)
+		headers[HeaderOriginalOffset] = strconv.FormatInt(msg.Offset, 10)
+	}
+
+	headers[HeaderError] = cause.Error()
+	headers[HeaderAttempts] = strconv.Itoa(attempts)
+	headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339Nano)
+	delete(headers, HeaderRetryAt)
+
+	return headers
+}
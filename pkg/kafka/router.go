@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidRoute = errors.New("route needs a topic and either a prefix or a pattern")
+)
+
+type (
+	// Router maps keys, such as channel IDs, to a fixed set of topics.
+	Router interface {
+		Topic(key string) string
+		Topics() []string
+	}
+
+	// Route sends the keys starting with Prefix, or matching the regular expression
+	// Pattern, to Topic.
+	Route struct {
+		Prefix  string
+		Pattern string
+		Topic   string
+	}
+
+	router struct {
+		routes   []route
+		fallback string
+	}
+
+	route struct {
+		prefix  string
+		pattern *regexp.Regexp
+		topic   string
+	}
+)
+
+// NewRouter returns a router trying the routes in order. Keys matching none of them
+// go to the fallback topic, or to the topic named after the key when it is empty.
+func NewRouter(routes []Route, fallback string) (Router, error) {
+	r := &router{fallback: fallback}
+
+	for _, rt := range routes {
+		if rt.Topic == "" || (rt.Prefix == "") == (rt.Pattern == "") {
+			return nil, ErrInvalidRoute
+		}
+
+		compiled := route{prefix: rt.Prefix, topic: rt.Topic}
+		if rt.Pattern != "" {
+			pattern, err := regexp.Compile(rt.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			compiled.pattern = pattern
+		}
+		r.routes = append(r.routes, compiled)
+	}
+
+	return r, nil
+}
+
+// Topic returns the topic of the key.
+func (r *router) Topic(key string) string {
+	for _, rt := range r.routes {
+		if rt.pattern != nil && rt.pattern.MatchString(key) {
+			return rt.topic
+		}
+		if rt.pattern == nil && strings.HasPrefix(key, rt.prefix) {
+			return rt.topic
+		}
+	}
+
+	if r.fallback == "" {
+		return key
+	}
+	return r.fallback
+}
+
+// Topics returns every topic keys are routed to, apart from the topics named after keys.
+func (r *router) Topics() []string {
+	var topics []string
+	seen := make(map[string]bool)
+
+	for _, rt := range r.routes {
+		if !seen[rt.topic] {
+			seen[rt.topic] = true
+			topics = append(topics, rt.topic)
+		}
+	}
+
+	if r.fallback != "" && !seen[r.fallback] {
+		topics = append(topics, r.fallback)
+	}
+
+	return topics
+}
@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SASL mechanisms the client can authenticate with.
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+var (
+	ErrUnknownSASLMechanism = errors.New("unknown SASL mechanism")
+	ErrInvalidCA            = errors.New("no certificate found in CA file")
+	ErrIncompleteKeyPair    = errors.New("client certificate and key files go together")
+)
+
+type (
+	TLSFiles struct {
+		// CAFile verifies the brokers' certificates, the system pool when empty.
+		CAFile string
+		// CertFile and KeyFile authenticate the client to the brokers (mTLS).
+		CertFile string
+		KeyFile  string
+		// InsecureSkipVerify accepts any broker certificate, for testing only.
+		InsecureSkipVerify bool
+	}
+
+	// scramClient adapts an xdg-go SCRAM conversation to sarama.
+	scramClient struct {
+		hashGenerator scram.HashGeneratorFcn
+		conversation  *scram.ClientConversation
+	}
+)
+
+// applySecurityConfig sets up the authentication and encryption part of the sarama
+// configuration.
+func applySecurityConfig(kafkaConfig *sarama.Config, config Config) error {
+	if config.Username != "" && config.Password != "" {
+		kafkaConfig.Net.SASL.Enable = true
+		kafkaConfig.Net.SASL.User = config.Username
+		kafkaConfig.Net.SASL.Password = config.Password
+
+		switch config.Mechanism {
+		case SASLPlain, "":
+			kafkaConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case SASLScramSHA256:
+			kafkaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			kafkaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: sha256.New}
+			}
+		case SASLScramSHA512:
+			kafkaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			kafkaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: sha512.New}
+			}
+		default:
+			return ErrUnknownSASLMechanism
+		}
+	}
+
+	if config.UseTLS {
+		kafkaConfig.Net.TLS.Enable = true
+		kafkaConfig.Net.TLS.Config = config.TLSConfig
+	}
+
+	return nil
+}
+
+// newTLSConfig loads the CA and the client certificate from the files. It returns nil,
+// the default TLS configuration, when no file is set.
+func newTLSConfig(files TLSFiles) (*tls.Config, error) {
+	if files == (TLSFiles{}) {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: files.InsecureSkipVerify,
+	}
+
+	if files.CAFile != "" {
+		ca, err := os.ReadFile(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, ErrInvalidCA
+		}
+	}
+
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, ErrIncompleteKeyPair
+	}
+	if files.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
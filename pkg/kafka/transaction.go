@@ -0,0 +1,115 @@
+package kafka
+
+import (
+	"errors"
+
+	"github.com/IBM/sarama"
+)
+
+var (
+	ErrNoTransactionalID = errors.New("transactional producer needs a transactional ID")
+)
+
+type (
+	// TransactionalProducer writes messages and consumed offsets atomically, so that a
+	// consume-transform-produce pipeline processes each message exactly once: either the
+	// messages it produced and its progress are both committed, or neither is. It runs a
+	// single transaction at a time, callers sharing it must serialize their transactions.
+	TransactionalProducer interface {
+		Begin() error
+		Send(topic string, key string, headers map[string]string, message interface{}) error
+		SendOffsets(group string, messages ...*Message) error
+		Commit() error
+		Abort() error
+		Close() error
+	}
+
+	transactionalProducer struct {
+		producer sarama.SyncProducer
+	}
+)
+
+// NewTransactionalProducer creates a producer whose messages are only visible to
+// read-committed consumers once its transaction is committed. The transactional ID
+// must be stable across restarts of the same pipeline instance, so that a restarted
+// instance fences off its previous incarnation and aborts its pending transaction.
+func (r *client) NewTransactionalProducer(transactionalID string) (TransactionalProducer, error) {
+	if transactionalID == "" {
+		return nil, ErrNoTransactionalID
+	}
+
+	kafkaConfig, err := newSaramaConfig(r.config)
+	if err != nil {
+		return nil, err
+	}
+	kafkaConfig.Producer.Return.Successes = true
+	kafkaConfig.Producer.Return.Errors = true
+	kafkaConfig.Producer.Idempotent = true
+	kafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	kafkaConfig.Producer.Transaction.ID = transactionalID
+	kafkaConfig.Net.MaxOpenRequests = 1
+
+	producer, err := sarama.NewSyncProducer(r.config.Brokers, kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transactionalProducer{producer: producer}, nil
+}
+
+// Begin starts a transaction, which must be ended by Commit or Abort.
+func (p *transactionalProducer) Begin() error {
+	return p.producer.BeginTxn()
+}
+
+// Send produces a message within the current transaction.
+func (p *transactionalProducer) Send(topic string, key string, headers map[string]string, message interface{}) error {
+	msg, err := newProducerMessage(topic, key, headers, message)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = p.producer.SendMessage(msg)
+	return err
+}
+
+// SendOffsets records the given messages as consumed by the group within the current
+// transaction, instead of committing them through the consumer. Each partition is
+// committed after the last of its messages.
+func (p *transactionalProducer) SendOffsets(group string, messages ...*Message) error {
+	next := make(map[string]map[int32]int64)
+	for _, msg := range messages {
+		if next[msg.Topic] == nil {
+			next[msg.Topic] = make(map[int32]int64)
+		}
+		if offset, ok := next[msg.Topic][msg.Partition]; !ok || msg.Offset+1 > offset {
+			next[msg.Topic][msg.Partition] = msg.Offset + 1
+		}
+	}
+
+	offsets := make(map[string][]*sarama.PartitionOffsetMetadata, len(next))
+	for topic, partitions := range next {
+		for partition, offset := range partitions {
+			offsets[topic] = append(offsets[topic], &sarama.PartitionOffsetMetadata{
+				Partition: partition,
+				Offset:    offset,
+			})
+		}
+	}
+
+	return p.producer.AddOffsetsToTxn(offsets, group)
+}
+
+// Commit makes the messages and the offsets of the current transaction visible.
+func (p *transactionalProducer) Commit() error {
+	return p.producer.CommitTxn()
+}
+
+// Abort discards the messages and the offsets of the current transaction.
+func (p *transactionalProducer) Abort() error {
+	return p.producer.AbortTxn()
+}
+
+func (p *transactionalProducer) Close() error {
+	return p.producer.Close()
+}
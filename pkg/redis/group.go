@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// XGroupCreate creates a consumer group reading the stream from its first entry. The
+// stream is created when missing, and an already existing group is not an error.
+func (r *client) XGroupCreate(stream, group string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout*time.Second)
+	defer cancel()
+
+	err := r.client.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// XReadGroup reads up to count entries never delivered to the group, waiting at most
+// block for new ones. The entries stay pending for the consumer until acknowledged.
+func (r *client) XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]*StreamMessage, error) {
+	streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*StreamMessage
+	for _, s := range streams {
+		messages = append(messages, toStreamMessages(s.Messages)...)
+	}
+
+	return messages, nil
+}
+
+// XClaim transfers to the consumer up to count entries pending in the group for at
+// least minIdle, so that entries left behind by a failed or dead consumer are retried.
+func (r *client) XClaim(stream, group, consumer string, minIdle time.Duration, count int64) ([]*StreamMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout*time.Second)
+	defer cancel()
+
+	pending, err := r.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Idle:   minIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(pending))
+	for _, entry := range pending {
+		ids = append(ids, entry.ID)
+	}
+
+	entries, err := r.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return toStreamMessages(entries), nil
+}
+
+// XAck acknowledges entries processed by the group so they are no longer pending.
+func (r *client) XAck(stream, group string, ids ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout*time.Second)
+	defer cancel()
+
+	return r.client.XAck(ctx, stream, group, ids...).Err()
+}
+
+// XDel removes entries from the stream.
+func (r *client) XDel(stream string, ids ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout*time.Second)
+	defer cancel()
+
+	return r.client.XDel(ctx, stream, ids...).Err()
+}
+
+func toStreamMessages(entries []redis.XMessage) []*StreamMessage {
+	messages := make([]*StreamMessage, 0, len(entries))
+	for _, entry := range entries {
+		messages = append(messages, &StreamMessage{
+			ID:     entry.ID,
+			Values: entry.Values,
+		})
+	}
+	return messages
+}
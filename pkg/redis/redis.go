@@ -3,7 +3,10 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -13,6 +16,11 @@ const (
 	Timeout = 1
 )
 
+var (
+	ErrInvalidStreamID = errors.New("invalid stream ID")
+	ErrNotFound        = errors.New("key not found")
+)
+
 type (
 	Client interface {
 		IsConnected() bool
@@ -24,7 +32,18 @@ type (
 		Remove(keys ...string) error
 
 		Publish(channel string, message interface{}) error
-		Subscribe(ctx context.Context, channel string) (<-chan *Message, error)
+		Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error)
+		PSubscribe(ctx context.Context, patterns ...string) (<-chan *Message, error)
+
+		XRange(stream, start, stop string) ([]*StreamMessage, error)
+
+		XGroupCreate(stream, group string) error
+		XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]*StreamMessage, error)
+		XClaim(stream, group, consumer string, minIdle time.Duration, count int64) ([]*StreamMessage, error)
+		XAck(stream, group string, ids ...string) error
+		XDel(stream string, ids ...string) error
+
+		Transaction(keys []string, fn func(tx Tx) error) error
 	}
 
 	client struct {
@@ -39,6 +58,11 @@ type (
 		Timestamp    time.Time
 	}
 
+	StreamMessage struct {
+		ID     string
+		Values map[string]interface{}
+	}
+
 	Config struct {
 		Address  string
 		Username string
@@ -99,10 +123,22 @@ func (r *client) Publish(channel string, message interface{}) error {
 	return r.client.Publish(ctx, channel, message).Err()
 }
 
-func (r *client) Subscribe(ctx context.Context, channel string) (<-chan *Message, error) {
-	pubsub := r.client.Subscribe(ctx, channel)
+// Subscribe listens to the given channels until ctx is canceled.
+func (r *client) Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
+	return r.receive(ctx, r.client.Subscribe(ctx, channels...))
+}
+
+// PSubscribe listens to every channel matching the given glob-style patterns
+// until ctx is canceled. Each message reports the pattern it matched.
+func (r *client) PSubscribe(ctx context.Context, patterns ...string) (<-chan *Message, error) {
+	return r.receive(ctx, r.client.PSubscribe(ctx, patterns...))
+}
+
+// receive waits for the subscription to be confirmed, then forwards its messages.
+func (r *client) receive(ctx context.Context, pubsub *redis.PubSub) (<-chan *Message, error) {
 	_, err := pubsub.Receive(ctx)
 	if err != nil {
+		pubsub.Close()
 		return nil, err
 	}
 
@@ -111,15 +147,26 @@ func (r *client) Subscribe(ctx context.Context, channel string) (<-chan *Message
 		defer close(ch)
 		defer pubsub.Close()
 
+		messages := pubsub.Channel()
 		for {
 			select {
-			case msg := <-pubsub.Channel():
-				ch <- &Message{
+			case msg, ok := <-messages:
+				if !ok {
+					log.Println("Redis pub/sub channel closed")
+					return
+				}
+
+				select {
+				case ch <- &Message{
 					Channel:      msg.Channel,
 					Pattern:      msg.Pattern,
 					Payload:      msg.Payload,
 					PayloadSlice: msg.PayloadSlice,
 					Timestamp:    time.Now(),
+				}:
+				case <-ctx.Done():
+					log.Println("Redis pub/sub channel stopped")
+					return
 				}
 
 			case <-ctx.Done():
@@ -132,11 +179,74 @@ func (r *client) Subscribe(ctx context.Context, channel string) (<-chan *Message
 	return ch, nil
 }
 
+// XRange returns the stream entries between start and stop, both inclusive.
+func (r *client) XRange(stream, start, stop string) ([]*StreamMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout*time.Second)
+	defer cancel()
+
+	entries, err := r.client.XRange(ctx, stream, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return toStreamMessages(entries), nil
+}
+
+// ParseStreamID splits a stream entry ID of the form "<ms>-<seq>" into its parts.
+// A bare "<ms>" is accepted and treated as "<ms>-0".
+func ParseStreamID(id string) (ms uint64, seq uint64, err error) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if ms, err = strconv.ParseUint(msPart, 10, 64); err != nil {
+		return 0, 0, ErrInvalidStreamID
+	}
+
+	if !found {
+		return ms, 0, nil
+	}
+
+	if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+		return 0, 0, ErrInvalidStreamID
+	}
+
+	return ms, seq, nil
+}
+
+// CompareStreamIDs returns -1, 0 or 1 depending on whether a is older than,
+// equal to or newer than b. Invalid IDs sort before every valid one.
+func CompareStreamIDs(a, b string) int {
+	aMs, aSeq, aErr := ParseStreamID(a)
+	bMs, bSeq, bErr := ParseStreamID(b)
+
+	switch {
+	case aErr != nil && bErr != nil:
+		return 0
+	case aErr != nil:
+		return -1
+	case bErr != nil:
+		return 1
+	case aMs != bMs:
+		if aMs < bMs {
+			return -1
+		}
+		return 1
+	case aSeq != bSeq:
+		if aSeq < bSeq {
+			return -1
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
 func (r *client) Get(key string, value interface{}) error {
 	ctx, cancel := context.WithTimeout(context.Background(), Timeout*time.Second)
 	defer cancel()
 
 	strValue, err := r.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
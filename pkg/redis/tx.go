@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// TxRetries is how many times a transaction is attempted before giving up
+	// because its watched keys keep being modified concurrently.
+	TxRetries = 10
+)
+
+var (
+	ErrTxConflict = errors.New("transaction aborted by concurrent modifications")
+)
+
+type (
+	// Tx is an optimistic transaction. Reads are performed right away against the
+	// watched keys, writes are queued and executed atomically with MULTI/EXEC once
+	// the transaction function returns, provided no watched key changed meanwhile.
+	Tx interface {
+		Get(key string) ([]byte, error)
+		XLast(stream string) (string, error)
+
+		Set(key string, value []byte, expiration time.Duration)
+		Remove(keys ...string)
+		XAdd(stream string, id string, maxLen int64, values map[string]interface{})
+		Publish(channel string, message interface{})
+	}
+
+	tx struct {
+		ctx    context.Context
+		tx     *redis.Tx
+		queued []func(pipe redis.Pipeliner)
+	}
+)
+
+// Transaction runs fn in an optimistic transaction watching the given keys. It is
+// retried from scratch when a watched key is modified before the writes are executed,
+// so fn must not have side effects besides the queued writes. An error returned by
+// fn aborts the transaction and is returned as is.
+func (r *client) Transaction(keys []string, fn func(tx Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout*time.Second)
+	defer cancel()
+
+	for attempt := 0; attempt < TxRetries; attempt++ {
+		err := r.client.Watch(ctx, func(rtx *redis.Tx) error {
+			t := &tx{ctx: ctx, tx: rtx}
+			if err := fn(t); err != nil {
+				return err
+			}
+
+			_, err := rtx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, queue := range t.queued {
+					queue(pipe)
+				}
+				return nil
+			})
+			return err
+		}, keys...)
+
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return ErrTxConflict
+}
+
+// Get returns the raw value of the key, or ErrNotFound.
+func (t *tx) Get(key string) ([]byte, error) {
+	value, err := t.tx.Get(t.ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+// XLast returns the ID of the newest entry of the stream, or an empty string.
+func (t *tx) XLast(stream string) (string, error) {
+	entries, err := t.tx.XRevRangeN(t.ctx, stream, "+", "-", 1).Result()
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+	return entries[0].ID, nil
+}
+
+func (t *tx) Set(key string, value []byte, expiration time.Duration) {
+	t.queued = append(t.queued, func(pipe redis.Pipeliner) {
+		pipe.Set(t.ctx, key, value, expiration)
+	})
+}
+
+func (t *tx) Remove(keys ...string) {
+	t.queued = append(t.queued, func(pipe redis.Pipeliner) {
+		pipe.Del(t.ctx, keys...)
+	})
+}
+
+// XAdd appends an entry with an explicit ID, which must be greater than the
+// stream's last one, or with an ID assigned by Redis when id is empty. When
+// maxLen is positive the stream is approximately capped.
+func (t *tx) XAdd(stream string, id string, maxLen int64, values map[string]interface{}) {
+	t.queued = append(t.queued, func(pipe redis.Pipeliner) {
+		pipe.XAdd(t.ctx, &redis.XAddArgs{
+			Stream: stream,
+			ID:     id,
+			MaxLen: maxLen,
+			Approx: maxLen > 0,
+			Values: values,
+		})
+	})
+}
+
+func (t *tx) Publish(channel string, message interface{}) {
+	t.queued = append(t.queued, func(pipe redis.Pipeliner) {
+		pipe.Publish(t.ctx, channel, message)
+	})
+}
@@ -0,0 +1,121 @@
+package sse
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field names of the text/event-stream format.
+const (
+	fieldID      = "id"
+	fieldEvent   = "event"
+	fieldData    = "data"
+	fieldRetry   = "retry"
+	fieldComment = ""
+)
+
+// lineBreaks normalizes the three line endings accepted by the WHATWG spec.
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// Event is a single Server-Sent Events frame.
+//
+// Data may be a string or []byte, which are written verbatim, or any other value,
+// which is JSON-encoded. Multi-line data is split over several "data" lines so the
+// client reassembles it unchanged. A zero Retry is omitted, as are empty fields.
+type Event struct {
+	ID      string
+	Event   string
+	Data    interface{}
+	Retry   time.Duration
+	Comment string
+}
+
+// WriteTo encodes the event in the text/event-stream format and writes it to w,
+// terminated by the blank line that makes the client dispatch it.
+func (e Event) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	for _, line := range splitLines(e.Comment) {
+		writeField(&buf, fieldComment, line)
+	}
+
+	// Line breaks would start a new field and a NULL makes clients ignore the ID
+	if id := sanitize(e.ID, "\x00"); id != "" {
+		writeField(&buf, fieldID, id)
+	}
+
+	if name := sanitize(e.Event, ""); name != "" {
+		writeField(&buf, fieldEvent, name)
+	}
+
+	if e.Retry > 0 {
+		writeField(&buf, fieldRetry, strconv.FormatInt(e.Retry.Milliseconds(), 10))
+	}
+
+	if e.Data != nil {
+		data, err := encodeData(e.Data)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, line := range strings.Split(lineBreaks.Replace(data), "\n") {
+			writeField(&buf, fieldData, line)
+		}
+	}
+
+	buf.WriteByte('\n')
+
+	return buf.WriteTo(w)
+}
+
+// encodeData renders the data of an event as text.
+func encodeData(data interface{}) (string, error) {
+	switch value := data.(type) {
+	case string:
+		return value, nil
+	case []byte:
+		return string(value), nil
+	case json.RawMessage:
+		return string(value), nil
+	}
+
+	bData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bData), nil
+}
+
+// writeField writes a single "name: value" line. Comments have an empty name.
+func writeField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	buf.WriteByte(':')
+	if value != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+}
+
+// splitLines splits a multi-line value, returning nothing for an empty one.
+func splitLines(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(lineBreaks.Replace(value), "\n")
+}
+
+// sanitize strips line breaks and the characters in cutset from a single-line field.
+func sanitize(value, cutset string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || strings.ContainsRune(cutset, r) {
+			return -1
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,108 @@
+package sse
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEventWriteTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    Event
+		expected string
+	}{
+		{
+			name:     "single-line data",
+			event:    Event{Data: "hello"},
+			expected: "data: hello\n\n",
+		},
+		{
+			name:     "multi-line data",
+			event:    Event{Data: "first\nsecond\r\nthird\rfourth"},
+			expected: "data: first\ndata: second\ndata: third\ndata: fourth\n\n",
+		},
+		{
+			name:     "blank data lines are kept",
+			event:    Event{Data: "first\n\nthird\n"},
+			expected: "data: first\ndata:\ndata: third\ndata:\n\n",
+		},
+		{
+			name:     "JSON data",
+			event:    Event{Data: map[string]int{"a": 1}},
+			expected: "data: {\"a\":1}\n\n",
+		},
+		{
+			name:     "raw JSON data",
+			event:    Event{Data: json.RawMessage(`{"a":1}`)},
+			expected: "data: {\"a\":1}\n\n",
+		},
+		{
+			name:     "line breaks stripped from the ID",
+			event:    Event{ID: "1\r\nevent: forged", Data: "x"},
+			expected: "id: 1event: forged\ndata: x\n\n",
+		},
+		{
+			name:     "NULL stripped from the ID",
+			event:    Event{ID: "1\x002", Data: "x"},
+			expected: "id: 12\ndata: x\n\n",
+		},
+		{
+			name:     "line breaks stripped from the event name",
+			event:    Event{Event: "update\ndata: forged", Data: "x"},
+			expected: "event: updatedata: forged\ndata: x\n\n",
+		},
+		{
+			name:     "empty ID and event name after sanitizing are omitted",
+			event:    Event{ID: "\n", Event: "\r\n", Data: "x"},
+			expected: "data: x\n\n",
+		},
+		{
+			name:     "retry in milliseconds",
+			event:    Event{Retry: 1500 * time.Millisecond},
+			expected: "retry: 1500\n\n",
+		},
+		{
+			name:     "zero retry omitted",
+			event:    Event{Retry: 0, Data: "x"},
+			expected: "data: x\n\n",
+		},
+		{
+			name:     "multi-line comment",
+			event:    Event{Comment: "ping\npong"},
+			expected: ": ping\n: pong\n\n",
+		},
+		{
+			name:     "every field",
+			event:    Event{Comment: "c", ID: "7", Event: "update", Retry: 2 * time.Second, Data: "x"},
+			expected: ": c\nid: 7\nevent: update\nretry: 2000\ndata: x\n\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := test.event.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("WriteTo: %v", err)
+			}
+			if got := buf.String(); got != test.expected {
+				t.Fatalf("got frame %q, expected %q", got, test.expected)
+			}
+			if n != int64(buf.Len()) {
+				t.Fatalf("got %d bytes written, expected %d", n, buf.Len())
+			}
+		})
+	}
+}
+
+func TestEventWriteToUnencodableData(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := (Event{Data: make(chan int)}).WriteTo(&buf); err == nil {
+		t.Fatal("WriteTo succeeded, expected a JSON encoding error")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("got %q written, expected nothing", buf.String())
+	}
+}
@@ -1,12 +1,14 @@
 package sse
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"time"
 )
 
 // Header constants for Server-Sent Events.
@@ -19,11 +21,38 @@ const (
 	ConnectionValue        = "keep-alive"
 	TransferEncodingHeader = "Transfer-Encoding"
 	TransferEncodingValue  = "chunked"
+	LastEventIDHeader      = "Last-Event-ID"
 )
 
+// Comments sent to keep the connection alive and before closing it.
+const (
+	PingComment     = "ping"
+	LifetimeComment = "stream lifetime reached, reconnect"
+)
+
+// Config controls how long a stream is kept open and how it is kept alive.
+// Zero values disable the corresponding behavior.
+type Config struct {
+	// KeepAlive is the interval of the comment frames sent while no event is
+	// delivered, which stops proxies from closing idle connections.
+	KeepAlive time.Duration
+
+	// MaxLifetime is the duration after which the stream is closed cleanly.
+	MaxLifetime time.Duration
+
+	// Retry is the reconnection delay advertised to the client when the
+	// stream is closed because it reached MaxLifetime.
+	Retry time.Duration
+
+	// WriteTimeout bounds each write, so a stalled client is detected
+	// instead of blocking the stream forever.
+	WriteTimeout time.Duration
+}
+
 // Error messages for various events and errors.
 var (
 	ErrResponseWriterNotFlushable = errors.New("response writer does not support flushing")
+	ErrNilEvent                   = errors.New("nil event")
 )
 
 // responseWriter abstracts http.ResponseWriter and http.Flusher.
@@ -32,6 +61,18 @@ type responseWriter interface {
 	http.Flusher
 }
 
+// Identifier is implemented by raw values that carry their own event ID. The ID is
+// written as the "id" field so reconnecting clients report it in Last-Event-ID.
+type Identifier interface {
+	EventID() string
+}
+
+// Namer is implemented by raw values that carry their own event type. The name is
+// written as the "event" field so clients can use addEventListener(name, ...).
+type Namer interface {
+	EventName() string
+}
+
 // setSSEHeaders sets the necessary headers for Server-Sent Events.
 func setSSEHeaders(w http.ResponseWriter) {
 	w.Header().Set(ContentTypeHeader, ContentTypeValue)
@@ -54,9 +95,38 @@ func validateData[T any](event T) ([]byte, error) {
 	return json.Marshal(event)
 }
 
+// toEvent wraps a value received on the stream channel into an Event.
+// Event values are used as they are, anything else becomes the JSON data of the frame.
+func toEvent(value interface{}) (Event, error) {
+	switch event := value.(type) {
+	case Event:
+		return event, nil
+	case *Event:
+		if event == nil {
+			return Event{}, ErrNilEvent
+		}
+		return *event, nil
+	}
+
+	data, err := validateData(value)
+	if err != nil {
+		return Event{}, err
+	}
+
+	event := Event{Data: data}
+	if identifier, ok := value.(Identifier); ok {
+		event.ID = identifier.EventID()
+	}
+	if namer, ok := value.(Namer); ok {
+		event.Event = namer.EventName()
+	}
+
+	return event, nil
+}
+
 // sendResponse handles sending the response to the client and flushing.
-func sendResponse(w responseWriter, data []byte) error {
-	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+func sendResponse(w responseWriter, event Event) error {
+	if _, err := event.WriteTo(w); err != nil {
 		return err
 	}
 	w.Flush()
@@ -65,7 +135,18 @@ func sendResponse(w responseWriter, data []byte) error {
 
 // Stream handles Server-Sent Events for the given context and events channel.
 // It streams events from the provided channel to the HTTP response writer.
-func Stream[T any](ctx context.Context, w http.ResponseWriter, eventCh chan T) error {
+// The channel may carry Event values to control every field of the frame,
+// or raw values which are JSON-encoded as the frame data.
+func Stream[T any](ctx context.Context, w http.ResponseWriter, eventCh <-chan T) error {
+	return StreamWithConfig(ctx, w, eventCh, Config{})
+}
+
+// StreamWithConfig behaves like Stream, additionally applying the keep-alive,
+// lifetime and write timeout settings of the given configuration.
+//
+// It returns as soon as a write to the client fails, so the caller can release
+// the resources feeding the channel by canceling ctx.
+func StreamWithConfig[T any](ctx context.Context, w http.ResponseWriter, eventCh <-chan T, config Config) error {
 	setSSEHeaders(w)
 
 	flusher, ok := w.(responseWriter)
@@ -74,6 +155,52 @@ func Stream[T any](ctx context.Context, w http.ResponseWriter, eventCh chan T) e
 	}
 	flusher.Flush()
 
+	return stream(ctx, eventCh, config, func(frame Event) error {
+		return sendWithConfig(flusher, frame, config)
+	})
+}
+
+// StreamBuffered behaves like StreamWithConfig for servers which hand a buffered writer
+// to stream the body to, such as fasthttp, flushing it after each frame. The caller sets
+// the headers of the response. The write timeout is not applied, a client that went
+// away is detected when a flush fails, at the latest on the next keep-alive.
+func StreamBuffered[T any](ctx context.Context, w *bufio.Writer, eventCh <-chan T, config Config) error {
+	// Send the headers right away
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return stream(ctx, eventCh, config, func(frame Event) error {
+		return SendBuffered(w, frame)
+	})
+}
+
+// SendBuffered writes a single frame to a buffered stream and flushes it.
+func SendBuffered(w *bufio.Writer, event Event) error {
+	if _, err := event.WriteTo(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// stream sends the events of the channel, the keep-alives and the final retry frame
+// until the channel is closed, ctx is done or send fails.
+func stream[T any](ctx context.Context, eventCh <-chan T, config Config, send func(frame Event) error) error {
+	var keepAlive *time.Ticker
+	var keepAliveC <-chan time.Time
+	if config.KeepAlive > 0 {
+		keepAlive = time.NewTicker(config.KeepAlive)
+		defer keepAlive.Stop()
+		keepAliveC = keepAlive.C
+	}
+
+	var lifetime <-chan time.Time
+	if config.MaxLifetime > 0 {
+		timer := time.NewTimer(config.MaxLifetime)
+		defer timer.Stop()
+		lifetime = timer.C
+	}
+
 	for {
 		select {
 		case event, ok := <-eventCh:
@@ -81,15 +208,36 @@ func Stream[T any](ctx context.Context, w http.ResponseWriter, eventCh chan T) e
 				return nil
 			}
 
-			data, err := validateData(event)
+			frame, err := toEvent(event)
 			if err != nil {
 				return fmt.Errorf("encoding event data: %w", err)
 			}
 
-			if err := sendResponse(flusher, data); err != nil {
+			if err := send(frame); err != nil {
 				return fmt.Errorf("writing to client: %w", err)
 			}
 
+			// The event kept the connection alive, the next ping is due a full interval later
+			if keepAlive != nil {
+				keepAlive.Reset(config.KeepAlive)
+				select {
+				case <-keepAliveC:
+				default:
+				}
+			}
+
+		case <-keepAliveC:
+			if err := send(Event{Comment: PingComment}); err != nil {
+				return fmt.Errorf("writing keep-alive to client: %w", err)
+			}
+
+		case <-lifetime:
+			// Tell the client when to come back, it resumes with Last-Event-ID
+			if err := send(Event{Comment: LifetimeComment, Retry: config.Retry}); err != nil {
+				return fmt.Errorf("writing retry to client: %w", err)
+			}
+			return nil
+
 		case <-ctx.Done():
 			if ctx.Err() == context.Canceled {
 				return nil
@@ -98,3 +246,23 @@ func Stream[T any](ctx context.Context, w http.ResponseWriter, eventCh chan T) e
 		}
 	}
 }
+
+// Send writes a single frame to a stream, e.g. a final frame once StreamWithConfig
+// returned, applying the write timeout of the configuration.
+func Send(w http.ResponseWriter, event Event, config Config) error {
+	flusher, ok := w.(responseWriter)
+	if !ok {
+		return ErrResponseWriterNotFlushable
+	}
+
+	return sendWithConfig(flusher, event, config)
+}
+
+// sendWithConfig bounds the write of the frame by the write timeout, if any.
+func sendWithConfig(w responseWriter, event Event, config Config) error {
+	if config.WriteTimeout > 0 {
+		// Not every writer supports deadlines, in which case writes simply block
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+	}
+	return sendResponse(w, event)
+}
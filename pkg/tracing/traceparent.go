@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// TraceParentHeader carries the trace context of a request, as defined by W3C Trace Context.
+const (
+	TraceParentHeader = "traceparent"
+
+	version     = "00"
+	sampledFlag = "01"
+)
+
+type traceParentKey struct{}
+
+// New returns the traceparent of a new sampled trace.
+func New() string {
+	return version + "-" + randomHex(16) + "-" + randomHex(8) + "-" + sampledFlag
+}
+
+// Child returns the traceparent of a span continuing the trace of the given parent, or
+// of a new trace when the parent is not a valid traceparent.
+func Child(parent string) string {
+	traceID, flags, ok := parse(parent)
+	if !ok {
+		return New()
+	}
+	return version + "-" + traceID + "-" + randomHex(8) + "-" + flags
+}
+
+// WithTraceParent returns a copy of ctx carrying the traceparent.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceParent)
+}
+
+// TraceParentFrom returns the traceparent carried by ctx, or an empty string.
+func TraceParentFrom(ctx context.Context) string {
+	traceParent, _ := ctx.Value(traceParentKey{}).(string)
+	return traceParent
+}
+
+// parse returns the trace ID and the flags of a traceparent of the form
+// "<version>-<trace-id>-<parent-id>-<flags>", of which the IDs must not be all zeros.
+func parse(value string) (traceID string, flags string, ok bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || parts[0] == "ff" || (parts[0] == version && len(parts) != 4) {
+		return "", "", false
+	}
+
+	if !isHex(parts[0], 2) || !isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return "", "", false
+	}
+
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+
+	return parts[1], parts[3], true
+}
+
+func isHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+
+	for _, c := range value {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,250 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DefaultSendBuffer     = 64
+	DefaultMaxMessageSize = 1 << 20
+
+	// Close codes of RFC 6455 the server ends a connection with.
+	CloseNormal          = websocket.CloseNormalClosure
+	ClosePolicyViolation = websocket.ClosePolicyViolation
+	CloseMessageTooBig   = websocket.CloseMessageTooBig
+	CloseInternalError   = websocket.CloseInternalServerErr
+
+	// closeGracePeriod bounds the write of the close frame.
+	closeGracePeriod = time.Second
+)
+
+var (
+	ErrSlowConsumer = errors.New("peer not reading fast enough")
+	ErrSendTimeout  = errors.New("send queue still full after the write timeout")
+	ErrClosed       = errors.New("connection closed")
+)
+
+// Config controls how a connection is kept alive and how far a peer may fall behind.
+// Zero durations disable the corresponding behavior.
+type Config struct {
+	// PingInterval is the interval of the pings sent to the peer, which stop proxies
+	// from closing idle connections and detect peers that went away.
+	PingInterval time.Duration
+
+	// PongTimeout is how long the peer has to answer a ping, or to send anything,
+	// before the connection is closed.
+	PongTimeout time.Duration
+
+	// WriteTimeout bounds each write, so a stalled peer is detected instead of
+	// blocking the connection forever. It also bounds the wait of Send for room in
+	// the queue.
+	WriteTimeout time.Duration
+
+	// SendBuffer is the number of messages queued for the peer. Send waits for
+	// room once a peer falls further behind.
+	SendBuffer int
+
+	// MaxMessageSize is the size in bytes of the largest message read from the peer.
+	MaxMessageSize int64
+
+	// CheckOrigin accepts or rejects the Origin of the handshake request. Only
+	// requests from the same host are accepted when nil.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// Conn is a WebSocket connection exchanging JSON messages. Reads happen on the
+// goroutine calling Receive, writes are queued by Send and performed by a goroutine
+// of the connection, so Send is safe to call from any goroutine.
+type Conn struct {
+	conn   *websocket.Conn
+	config Config
+	send   chan []byte
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// Upgrade turns the request into a WebSocket connection. On failure, an HTTP error
+// has already been answered to the client.
+func Upgrade(w http.ResponseWriter, r *http.Request, config Config) (*Conn, error) {
+	if config.SendBuffer <= 0 {
+		config.SendBuffer = DefaultSendBuffer
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = DefaultMaxMessageSize
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: config.CheckOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		conn:   conn,
+		config: config,
+		send:   make(chan []byte, config.SendBuffer),
+		done:   make(chan struct{}),
+	}
+
+	conn.SetReadLimit(config.MaxMessageSize)
+	c.extendReadDeadline()
+	conn.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+
+	go c.writeLoop()
+
+	return c, nil
+}
+
+// Receive waits for the next message of the peer and decodes it into v. It returns
+// an error once the connection is closed, by either side.
+func (c *Conn) Receive(v interface{}) error {
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		if errors.Is(err, websocket.ErrReadLimit) {
+			c.CloseWithReason(CloseMessageTooBig, err.Error())
+		} else {
+			c.closeWith(err, CloseNormal, "")
+		}
+		return c.Err()
+	}
+	c.extendReadDeadline()
+
+	return json.Unmarshal(data, v)
+}
+
+// Send encodes v and queues it for the peer, waiting for room in the queue up to the
+// write timeout, or until the connection is closed when there is none. ErrSendTimeout
+// is returned when the peer did not catch up in time, the connection staying open so
+// the caller decides what to give up on, see Disconnect.
+func (c *Conn) Send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+
+	var expired <-chan time.Time
+	if c.config.WriteTimeout > 0 {
+		timer := time.NewTimer(c.config.WriteTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case c.send <- data:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-expired:
+		return ErrSendTimeout
+	}
+}
+
+// Disconnect closes the connection of a peer not reading fast enough, Err then
+// reporting ErrSlowConsumer.
+func (c *Conn) Disconnect() {
+	c.closeWith(ErrSlowConsumer, ClosePolicyViolation, ErrSlowConsumer.Error())
+}
+
+// Done is closed once the connection is closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err reports why the connection was closed, nil while it is open.
+func (c *Conn) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close closes the connection normally. It is safe to call more than once.
+func (c *Conn) Close() {
+	c.CloseWithReason(CloseNormal, "")
+}
+
+// CloseWithReason closes the connection, telling the peer why with a close code of
+// RFC 6455 and a short reason.
+func (c *Conn) CloseWithReason(code int, reason string) {
+	c.closeWith(ErrClosed, code, reason)
+}
+
+// closeWith sends the close frame and closes the connection, recording err as the
+// reason it was closed. Only the first call has an effect.
+func (c *Conn) closeWith(err error, code int, reason string) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+
+		// WriteControl is safe to call concurrently with the write loop
+		message := websocket.FormatCloseMessage(code, reason)
+		_ = c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeGracePeriod))
+		_ = c.conn.Close()
+	})
+}
+
+// writeLoop writes the queued messages and the pings until the connection is closed.
+func (c *Conn) writeLoop() {
+	var ping <-chan time.Time
+	if c.config.PingInterval > 0 {
+		ticker := time.NewTicker(c.config.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case data := <-c.send:
+			c.setWriteDeadline()
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.closeWith(err, CloseInternalError, "")
+				return
+			}
+
+		case <-ping:
+			deadline := time.Now().Add(closeGracePeriod)
+			if c.config.WriteTimeout > 0 {
+				deadline = time.Now().Add(c.config.WriteTimeout)
+			}
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				c.closeWith(err, CloseInternalError, "")
+				return
+			}
+
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Conn) setWriteDeadline() {
+	if c.config.WriteTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+	}
+}
+
+// extendReadDeadline gives the peer another ping interval and pong timeout to show
+// it is still there.
+func (c *Conn) extendReadDeadline() {
+	if c.config.PingInterval > 0 && c.config.PongTimeout > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.config.PingInterval + c.config.PongTimeout))
+	}
+}